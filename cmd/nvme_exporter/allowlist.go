@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// _deviceArg stands for a validated NVMe device path in _allowedCommands.
+const _deviceArg = "<device>"
+
+// _numberArg stands for a validated number, such as an endurance group ID.
+const _numberArg = "<number>"
+
+// _captureFileArg stands for the file a telemetry capture is written to, see
+// isCaptureFile.
+const _captureFileArg = "<capture-file>"
+
+// _allowedCommands are the only nvme-cli argument lists the exporter may run.
+// Every argument must match literally, except _deviceArg which must be an
+// NVMe device path and _numberArg which must be a number. Anything that could modify a device (format, sanitize,
+// fw-activate, set-feature, ...) must never be added here.
+var _allowedCommands = [][]string{
+	{"--version"},
+	{"list", "-o", "json"},
+	{"smart-log", _deviceArg, "-o", "json"},
+	{"ocp", "smart-add-log", _deviceArg, "-o", "json"},
+	{"ocp", "latency-monitor-log", _deviceArg, "-o", "json"},
+	{"ocp", "error-recovery-log", _deviceArg, "-o", "json"},
+	{"ocp", "device-capability-log", _deviceArg, "-o", "json"},
+	{"ocp", "unsupported-reqs-log", _deviceArg, "-o", "json"},
+	{"id-ctrl", _deviceArg, "-o", "json"},
+	{"id-ns", _deviceArg, "-o", "json"},
+	{"sanitize-log", _deviceArg, "-o", "json"},
+	{"zns", "id-ns", _deviceArg, "-o", "json"},
+	{"zns", "report-zones", _deviceArg, "--descs", "1", "--state", _numberArg, "-o", "json"},
+	{"id-endurance-grp-list", _deviceArg, "-o", "json"},
+	{"endurance-log", _deviceArg, "--group-id", _numberArg, "-o", "json"},
+	{"persistent-event-log", _deviceArg, "--action=1", "-o", "json"},
+	{"persistent-event-log", _deviceArg, "--action=2"},
+	{"get-log", _deviceArg, "--log-id=7", "--log-len=512", "--raw-binary"},
+	{"get-log", _deviceArg, "--log-id=8", "--log-len=512", "--raw-binary"},
+	{"get-feature", _deviceArg, "--feature-id", _numberArg},
+	{"get-feature", _deviceArg, "--feature-id", "4", "--cdw11", _numberArg},
+	{"get-feature", _deviceArg, "--feature-id", "12", "--raw-binary"},
+	// Telemetry captures only run from the authenticated capture endpoint.
+	// They write the log to a file, the device data is left untouched.
+	{"telemetry-log", _deviceArg, "--output-file", _captureFileArg, "--host-generate", "1", "--data-area", _numberArg},
+	{"telemetry-log", _deviceArg, "--output-file", _captureFileArg, "--controller-init", "--data-area", _numberArg},
+}
+
+var _rejectedCommands = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "nvme_exporter_rejected_commands_total",
+		Help: "Number of nvme-cli invocations rejected by the read-only command allowlist",
+	},
+	[]string{"subcommand"},
+)
+
+func matchesAllowed(pattern, args []string) bool {
+	if len(pattern) != len(args) {
+		return false
+	}
+
+	for i, arg := range args {
+		switch pattern[i] {
+		case _deviceArg:
+			if !_devicePathRe.MatchString(arg) {
+				return false
+			}
+		case _numberArg:
+			if !_numberRe.MatchString(arg) {
+				return false
+			}
+		case _captureFileArg:
+			if !isCaptureFile(arg) {
+				return false
+			}
+		default:
+			if pattern[i] != arg {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// isCaptureFile accepts absolute, clean paths of .bin files, so a capture can
+// neither escape its directory nor be taken for an option.
+func isCaptureFile(path string) bool {
+	return filepath.IsAbs(path) && filepath.Clean(path) == path && strings.HasSuffix(path, ".bin")
+}
+
+// checkCommandAllowed guards every command the exporter runs against the
+// allowlist, counting and logging rejected ones.
+func checkCommandAllowed(cmd string, args []string) error {
+	if cmd == "nvme" {
+		for _, pattern := range _allowedCommands {
+			if matchesAllowed(pattern, args) {
+				return nil
+			}
+		}
+	}
+
+	subcommand := cmd
+	if len(args) > 0 {
+		subcommand = args[0]
+	}
+
+	_rejectedCommands.WithLabelValues(subcommand).Inc()
+	log.Printf("Rejected command not in allowlist: %s %s\n", cmd, strings.Join(args, " "))
+
+	return fmt.Errorf("command not allowed: %s %s", cmd, strings.Join(args, " "))
+}
+
+// checkQueriesAllowed enumerates every collector query and verifies the
+// allowlist permits it, so a query added without an allowlist entry fails at
+// startup rather than at scrape time.
+func checkQueriesAllowed() error {
+	queries := make([]string, 0, len(_queries)+len(_numberQueries))
+	for query := range _queries {
+		queries = append(queries, query)
+	}
+
+	for query := range _numberQueries {
+		queries = append(queries, query+":1")
+	}
+
+	for _, query := range queries {
+		args, err := queryArgs(query, "/dev/nvme0n1")
+		if err != nil {
+			return err
+		}
+
+		allowed := false
+		for _, pattern := range _allowedCommands {
+			allowed = allowed || matchesAllowed(pattern, args)
+		}
+
+		if !allowed {
+			return fmt.Errorf("query %s runs a command not in the allowlist: nvme %s", query, strings.Join(args, " "))
+		}
+	}
+
+	return nil
+}
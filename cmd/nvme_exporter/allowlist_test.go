@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testDevice = "/dev/nvme0n1"
+
+// testQueries returns every query name the collectors can issue, with a
+// number for _numberQueries.
+func testQueries(t *testing.T) []string {
+	t.Helper()
+
+	queries := make([]string, 0, len(_queries)+len(_numberQueries))
+	for query := range _queries {
+		queries = append(queries, query)
+	}
+
+	for query := range _numberQueries {
+		queries = append(queries, query+":1")
+	}
+
+	for query := range _binaryQueries {
+		_, plain := _queries[query]
+		_, number := _numberQueries[query]
+
+		if !plain && !number {
+			t.Errorf("binary query %s is neither in _queries nor in _numberQueries", query)
+		}
+	}
+
+	sort.Strings(queries)
+
+	return queries
+}
+
+func TestQueriesAllowed(t *testing.T) {
+	for _, query := range testQueries(t) {
+		t.Run(query, func(t *testing.T) {
+			args, err := queryArgs(query, testDevice)
+			if err != nil {
+				t.Fatalf("queryArgs: %s", err)
+			}
+
+			rejected := testutil.ToFloat64(_rejectedCommands.WithLabelValues(args[0]))
+
+			err = checkCommandAllowed("nvme", args)
+			if err != nil {
+				t.Errorf("nvme %s: %s", strings.Join(args, " "), err)
+			}
+
+			if got := testutil.ToFloat64(_rejectedCommands.WithLabelValues(args[0])); got != rejected {
+				t.Errorf("rejected commands counter changed from %v to %v", rejected, got)
+			}
+		})
+	}
+
+	err := checkQueriesAllowed()
+	if err != nil {
+		t.Errorf("checkQueriesAllowed: %s", err)
+	}
+}
+
+func TestCaptureCommandsAllowed(t *testing.T) {
+	for _, args := range [][]string{
+		{"--version"},
+		{"telemetry-log", testDevice, "--output-file", "/var/lib/nvme/S123-host.bin", "--host-generate", "1",
+			"--data-area", "3"},
+		{"telemetry-log", testDevice, "--output-file", "/var/lib/nvme/S123-controller.bin", "--controller-init",
+			"--data-area", "4"},
+	} {
+		err := checkCommandAllowed("nvme", args)
+		if err != nil {
+			t.Errorf("nvme %s: %s", strings.Join(args, " "), err)
+		}
+	}
+}
+
+func TestCommandsRejected(t *testing.T) {
+	for _, args := range [][]string{
+		{"format", testDevice, "--ses=1", "--force"},
+		{"format", testDevice},
+		{"sanitize", testDevice, "--sanact=2"},
+		{"sanitize", testDevice},
+		{"fw-activate", testDevice, "--slot=1", "--action=1"},
+		{"fw-activate", testDevice},
+		{"set-feature", testDevice, "--feature-id", "2", "--value", "4"},
+		{"smart-log", "/dev/sda", "-o", "json"},
+		{"smart-log", testDevice, "-o", "json", "--verbose"},
+		{"endurance-log", testDevice, "--group-id", "1; reboot", "-o", "json"},
+		{"telemetry-log", testDevice, "--output-file", "/var/lib/nvme/../../etc/passwd.bin", "--host-generate", "1",
+			"--data-area", "3"},
+		{"telemetry-log", testDevice, "--output-file", "capture.bin", "--host-generate", "1", "--data-area", "3"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			rejected := testutil.ToFloat64(_rejectedCommands.WithLabelValues(args[0]))
+
+			err := checkCommandAllowed("nvme", args)
+			if err == nil {
+				t.Errorf("nvme %s was allowed", strings.Join(args, " "))
+			}
+
+			if got := testutil.ToFloat64(_rejectedCommands.WithLabelValues(args[0])); got != rejected+1 {
+				t.Errorf("rejected commands counter is %v, want %v", got, rejected+1)
+			}
+		})
+	}
+}
+
+func TestOtherBinariesRejected(t *testing.T) {
+	rejected := testutil.ToFloat64(_rejectedCommands.WithLabelValues("smart-log"))
+
+	err := checkCommandAllowed("sh", []string{"smart-log", testDevice, "-o", "json"})
+	if err == nil {
+		t.Error("sh was allowed")
+	}
+
+	if got := testutil.ToFloat64(_rejectedCommands.WithLabelValues("smart-log")); got != rejected+1 {
+		t.Errorf("rejected commands counter is %v, want %v", got, rejected+1)
+	}
+}
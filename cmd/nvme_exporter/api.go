@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+type apiDevice struct {
+	Device       string                 `json:"device"`
+	GenericPath  string                 `json:"generic_path"`
+	Firmware     string                 `json:"firmware"`
+	ModelNumber  string                 `json:"model_number"`
+	SerialNumber string                 `json:"serial_number"`
+	NameSpace    int64                  `json:"namespace"`
+	UsedBytes    int64                  `json:"used_bytes"`
+	MaximumLba   int64                  `json:"maximum_lba"`
+	PhysicalSize int64                  `json:"physical_size"`
+	SectorSize   int64                  `json:"sector_size"`
+	SmartLog     map[string]interface{} `json:"smart_log,omitempty"`
+	OcpSmartLog  map[string]interface{} `json:"ocp_smart_log,omitempty"`
+	Sanitizing   bool                   `json:"sanitizing"`
+	Errors       []string               `json:"errors"`
+	CollectedAt  time.Time              `json:"collected_at"`
+}
+
+type apiDeviceList struct {
+	Devices     []apiDevice `json:"devices"`
+	Errors      []string    `json:"errors"`
+	CollectedAt time.Time   `json:"collected_at"`
+}
+
+// apiHandler serves the JSON inventory API from the collector's latest snapshot.
+type apiHandler struct {
+	collector *nvmeCollector
+}
+
+func newAPIDevice(device deviceSnapshot) apiDevice {
+	return apiDevice{
+		Device:       device.info.Get("DevicePath").String(),
+		GenericPath:  device.info.Get("GenericPath").String(),
+		Firmware:     device.info.Get("Firmware").String(),
+		ModelNumber:  device.info.Get("ModelNumber").String(),
+		SerialNumber: device.info.Get("SerialNumber").String(),
+		NameSpace:    device.info.Get("NameSpace").Int(),
+		UsedBytes:    device.info.Get("UsedBytes").Int(),
+		MaximumLba:   device.info.Get("MaximumLBA").Int(),
+		PhysicalSize: device.info.Get("PhysicalSize").Int(),
+		SectorSize:   device.info.Get("SectorSize").Int(),
+		SmartLog:     logValues(device.smartLog, _smartLogFields),
+		OcpSmartLog:  logValues(device.ocpSmartLog, _ocpSmartLogFields),
+		Sanitizing:   device.sanitizing,
+		Errors:       nonNil(device.errors),
+		CollectedAt:  device.collectedAt,
+	}
+}
+
+// logValues picks the exported fields out of a log page, skipping the ones the
+// device did not report.
+func logValues(logPage gjson.Result, fields []string) map[string]interface{} {
+	if !logPage.Exists() {
+		return nil
+	}
+
+	values := make(map[string]interface{}, len(fields))
+
+	for i, value := range gjson.GetMany(logPage.Raw, fields...) {
+		if value.Exists() {
+			values[fields[i]] = value.Value()
+		}
+	}
+
+	return values
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
+
+func (h *apiHandler) listDevices(w http.ResponseWriter, _ *http.Request) {
+	snap := h.collector.latest()
+	list := apiDeviceList{
+		Devices:     make([]apiDevice, 0, len(snap.devices)),
+		Errors:      nonNil(snap.errors),
+		CollectedAt: snap.collectedAt,
+	}
+
+	for _, device := range snap.devices {
+		list.Devices = append(list.Devices, newAPIDevice(device))
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *apiHandler) getDevice(w http.ResponseWriter, r *http.Request) {
+	serial := r.PathValue("serial")
+
+	for _, device := range h.collector.latest().devices {
+		if device.info.Get("SerialNumber").String() == serial {
+			writeJSON(w, http.StatusOK, newAPIDevice(device))
+
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "device not found: " + serial})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Printf("Error writing API response: %s\n", err)
+	}
+}
+
+func registerAPIHandlers(mux *http.ServeMux, collector *nvmeCollector) {
+	handler := &apiHandler{collector: collector}
+	mux.HandleFunc("GET /api/v1/devices", handler.listDevices)
+	mux.HandleFunc("GET /api/v1/devices/{serial}", handler.getDevice)
+}
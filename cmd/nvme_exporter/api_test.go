@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// testAPI returns a server for the API of a collector whose latest snapshot
+// holds two namespaces, the second one sanitizing.
+func testAPI(t *testing.T) (*httptest.Server, time.Time) {
+	t.Helper()
+
+	collectedAt := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	collector := newNvmeCollector(false, _defaultIdentity)
+	collector.last = &snapshot{
+		devices: []deviceSnapshot{
+			{
+				info: gjson.Parse(`{"DevicePath":"/dev/nvme0n1","GenericPath":"/dev/ng0n1","Firmware":"GDC5302Q",` +
+					`"ModelNumber":"TEST MODEL","SerialNumber":"S1","NameSpace":1,"UsedBytes":4096,` +
+					`"MaximumLBA":2048,"PhysicalSize":8192,"SectorSize":4096}`),
+				smartLog:    gjson.Parse(`{"temperature":310,"percent_used":3,"unknown_field":1}`),
+				collectedAt: collectedAt,
+			},
+			{
+				info:        gjson.Parse(`{"DevicePath":"/dev/nvme1n1","SerialNumber":"S2"}`),
+				sanitizing:  true,
+				errors:      []string{"error running smart-log /dev/nvme1n1"},
+				collectedAt: collectedAt,
+			},
+		},
+		errors:      []string{"error listing devices"},
+		collectedAt: collectedAt,
+	}
+
+	mux := http.NewServeMux()
+	registerAPIHandlers(mux, collector)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server, collectedAt
+}
+
+func getJSON(t *testing.T, method, url string, body interface{}) int {
+	t.Helper()
+
+	request, err := http.NewRequestWithContext(context.Background(), method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if body != nil && response.StatusCode < http.StatusMultipleChoices {
+		err = json.NewDecoder(response.Body).Decode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return response.StatusCode
+}
+
+func TestAPIListDevices(t *testing.T) {
+	server, collectedAt := testAPI(t)
+
+	var list apiDeviceList
+	if status := getJSON(t, http.MethodGet, server.URL+"/api/v1/devices", &list); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+
+	want := apiDeviceList{
+		Devices: []apiDevice{
+			{
+				Device: "/dev/nvme0n1", GenericPath: "/dev/ng0n1", Firmware: "GDC5302Q", ModelNumber: "TEST MODEL",
+				SerialNumber: "S1", NameSpace: 1, UsedBytes: 4096, MaximumLba: 2048, PhysicalSize: 8192,
+				SectorSize: 4096, SmartLog: map[string]interface{}{"temperature": 310.0, "percent_used": 3.0},
+				Errors: []string{}, CollectedAt: collectedAt,
+			},
+			{
+				Device: "/dev/nvme1n1", SerialNumber: "S2", Sanitizing: true,
+				Errors: []string{"error running smart-log /dev/nvme1n1"}, CollectedAt: collectedAt,
+			},
+		},
+		Errors:      []string{"error listing devices"},
+		CollectedAt: collectedAt,
+	}
+
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("devices = %+v, want %+v", list, want)
+	}
+}
+
+func TestAPIGetDevice(t *testing.T) {
+	server, _ := testAPI(t)
+
+	var device apiDevice
+	if status := getJSON(t, http.MethodGet, server.URL+"/api/v1/devices/S2", &device); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+
+	if device.Device != "/dev/nvme1n1" || !device.Sanitizing || device.SmartLog != nil {
+		t.Errorf("device = %+v", device)
+	}
+
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/api/v1/devices/S3", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/devices", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/v1/devices/S1", http.StatusMethodNotAllowed},
+	} {
+		if status := getJSON(t, test.method, server.URL+test.path, nil); status != test.status {
+			t.Errorf("%s %s returned %d, want %d", test.method, test.path, status, test.status)
+		}
+	}
+}
+
+func TestAPIDeviceJSON(t *testing.T) {
+	data, err := json.Marshal(newAPIDevice(deviceSnapshot{info: gjson.Parse(`{"SerialNumber":"S1"}`)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Logs the device did not return are left out, errors are an empty list.
+	for key, want := range map[string]string{
+		"serial_number": `"S1"`,
+		"errors":        "[]",
+		"smart_log":     "",
+		"ocp_smart_log": "",
+		"sanitizing":    "false",
+	} {
+		if got := gjson.GetBytes(data, key).Raw; got != want {
+			t.Errorf("%s = %s, want %s", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Nagios plugin exit codes.
+const (
+	exitOK       = 0
+	exitWarning  = 1
+	exitCritical = 2
+	exitUnknown  = 3
+)
+
+var _statusNames = map[int]string{
+	exitOK:       "OK",
+	exitWarning:  "WARNING",
+	exitCritical: "CRITICAL",
+	exitUnknown:  "UNKNOWN",
+}
+
+// _statusSeverity orders the plugin states from the least to the most severe.
+// A device that cannot be read must not hide another one that is critical.
+var _statusSeverity = map[int]int{
+	exitOK:       0,
+	exitUnknown:  1,
+	exitWarning:  2,
+	exitCritical: 3,
+}
+
+// worseStatus returns the more severe of two plugin states.
+func worseStatus(a, b int) int {
+	if _statusSeverity[b] > _statusSeverity[a] {
+		return b
+	}
+
+	return a
+}
+
+// _subcommands are the one-shot commands run instead of the exporter when
+// given as the first argument.
+var _subcommands = map[string]func(args []string) int{
+	"list":  runList,
+	"dump":  runDump,
+	"check": runCheck,
+
+	"check-health": runCheckHealth,
+	"compliance":   runCompliance,
+	"helper":       runHelper,
+}
+
+// devicePath accepts both "nvme0n1" and "/dev/nvme0n1" style device names.
+func devicePath(name string) string {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return name
+	}
+
+	return "/dev/" + name
+}
+
+func runList(args []string) int {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Println("Usage: nvme_exporter list")
+		fmt.Println("Lists the devices the exporter would collect metrics for.")
+	}
+	_ = flags.Parse(args)
+
+	return writeDeviceList(os.Stdout, newNvmeCollector(false, _defaultIdentity))
+}
+
+func writeDeviceList(out io.Writer, collector *nvmeCollector) int {
+	nvmeDeviceList, err := collector.getDeviceList()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return 1
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tGENERIC\tMODEL\tSERIAL\tFIRMWARE")
+
+	for _, device := range nvmeDeviceList {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			device.Get("DevicePath").String(),
+			device.Get("GenericPath").String(),
+			device.Get("ModelNumber").String(),
+			device.Get("SerialNumber").String(),
+			device.Get("Firmware").String())
+	}
+
+	_ = w.Flush()
+
+	return 0
+}
+
+func runDump(args []string) int {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	ocp := flags.Bool("ocp", false, "Enable OCP smart log metrics")
+	flags.Usage = func() {
+		fmt.Println("Usage: nvme_exporter dump [options] [device]")
+		fmt.Println("Prints the metrics the exporter would expose, for all devices or a single one.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	collector := newNvmeCollector(*ocp, _defaultIdentity)
+	collector.device = devicePath(flags.Arg(0))
+
+	return writeMetrics(os.Stdout, collector)
+}
+
+// writeMetrics prints the metrics of the collector in the text exposition
+// format, failing if the collector is limited to a device that is not found.
+func writeMetrics(out io.Writer, collector *nvmeCollector) int {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error gathering metrics: %s\n", err)
+
+		return 1
+	}
+
+	if collector.device != "" && len(collector.latest().devices) == 0 {
+		fmt.Fprintf(os.Stderr, "Device %s not found\n", collector.device)
+
+		return 1
+	}
+
+	for _, metricFamily := range metricFamilies {
+		_, err = expfmt.MetricFamilyToText(out, metricFamily)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing metrics: %s\n", err)
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// checkResult is a single line of the check report.
+type checkResult struct {
+	status  int
+	message string
+}
+
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	ocp := flags.Bool("ocp", false, "Also check OCP smart log availability")
+	flags.Usage = func() {
+		fmt.Println("Usage: nvme_exporter check [options]")
+		fmt.Println("Verifies the exporter can collect metrics on this host. Exits with Nagios plugin codes.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	return writeCheckResults(os.Stdout, runChecks(*ocp))
+}
+
+// writeCheckResults prints the check report and returns the exit code of the
+// worst result.
+func writeCheckResults(out io.Writer, results []checkResult) int {
+	worst := exitOK
+	for _, result := range results {
+		worst = worseStatus(worst, result.status)
+	}
+
+	fmt.Fprintf(out, "NVME %s\n", _statusNames[worst])
+
+	for _, result := range results {
+		fmt.Fprintf(out, "%s: %s\n", _statusNames[result.status], result.message)
+	}
+
+	return worst
+}
+
+func runChecks(ocp bool) []checkResult {
+	err := checkPrivileges()
+	if err != nil {
+		return []checkResult{{exitUnknown, err.Error()}}
+	}
+
+	results := []checkResult{{exitOK, "CAP_SYS_ADMIN held and NVMe device nodes readable"}}
+
+	version, err := nvmeCliVersion()
+	if err != nil {
+		return append(results, checkResult{exitUnknown, err.Error()})
+	}
+
+	if isSupportedVersion(version) {
+		results = append(results, checkResult{exitOK, "nvme-cli version " + version + " is supported"})
+	} else {
+		results = append(results, checkResult{exitWarning, "nvme-cli version " + version + " is not supported"})
+	}
+
+	return append(results, checkDevices(newNvmeCollector(ocp, _defaultIdentity))...)
+}
+
+// checkDevices collects the devices once and reports the ones whose log pages
+// could not be read.
+func checkDevices(collector *nvmeCollector) []checkResult {
+	var results []checkResult
+
+	snap := collector.refresh()
+	for _, message := range snap.errors {
+		results = append(results, checkResult{exitCritical, message})
+	}
+
+	if len(snap.devices) == 0 && len(snap.errors) == 0 {
+		results = append(results, checkResult{exitWarning, "no NVMe devices found"})
+	}
+
+	for _, device := range snap.devices {
+		path := device.info.Get("DevicePath").String()
+		if len(device.errors) == 0 {
+			results = append(results, checkResult{exitOK, path + ": log pages available"})
+		}
+
+		for _, message := range device.errors {
+			results = append(results, checkResult{exitWarning, path + ": " + message})
+		}
+	}
+
+	return results
+}
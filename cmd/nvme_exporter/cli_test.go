@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDeviceList = `{"Devices":[
+  {"DevicePath":"/dev/nvme0n1","GenericPath":"/dev/ng0n1","ModelNumber":"MODEL A","SerialNumber":"S0",
+   "Firmware":"FW1","NameSpace":1},
+  {"DevicePath":"/dev/nvme1n1","GenericPath":"/dev/ng1n1","ModelNumber":"MODEL B","SerialNumber":"S1",
+   "Firmware":"FW2","NameSpace":1}
+]}`
+
+// testRunner returns a query runner answering with the outputs, by query and
+// device or by query alone, and failing any other query.
+func testRunner(outputs map[string]string) queryRunner {
+	return func(query, device string) ([]byte, error) {
+		if output, ok := outputs[query+" "+device]; ok {
+			return []byte(output), nil
+		}
+
+		if output, ok := outputs[query]; ok {
+			return []byte(output), nil
+		}
+
+		return nil, errors.New("exit status 1")
+	}
+}
+
+// testCliCollector returns a collector running the outputs with the default
+// identity and no sysfs.
+func testCliCollector(t *testing.T, outputs map[string]string) *nvmeCollector {
+	t.Helper()
+
+	collector := newNvmeCollector(false, testIdentity(t.TempDir()))
+	collector.run = testRunner(outputs)
+
+	return collector
+}
+
+func TestWriteDeviceList(t *testing.T) {
+	var out bytes.Buffer
+
+	if code := writeDeviceList(&out, testCliCollector(t, map[string]string{"list": testDeviceList})); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+
+	want := "DEVICE        GENERIC     MODEL    SERIAL  FIRMWARE\n" +
+		"/dev/nvme0n1  /dev/ng0n1  MODEL A  S0      FW1\n" +
+		"/dev/nvme1n1  /dev/ng1n1  MODEL B  S1      FW2\n"
+	if out.String() != want {
+		t.Errorf("list output:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	if code := writeDeviceList(&out, testCliCollector(t, nil)); code != 1 {
+		t.Errorf("exit code %d when nvme list fails, want 1", code)
+	}
+}
+
+func TestWriteMetricsDevice(t *testing.T) {
+	outputs := map[string]string{
+		"list":                   testDeviceList,
+		"smart-log /dev/nvme0n1": `{"temperature":310}`,
+		"smart-log /dev/nvme1n1": `{"temperature":320}`,
+	}
+
+	var out bytes.Buffer
+
+	collector := testCliCollector(t, outputs)
+	collector.device = devicePath("nvme1n1")
+
+	if code := writeMetrics(&out, collector); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+
+	if !strings.Contains(out.String(), `nvme_temperature{device="/dev/nvme1n1"} 320`) {
+		t.Errorf("dump of nvme1n1 has no temperature:\n%s", out.String())
+	}
+
+	if strings.Contains(out.String(), "/dev/nvme0n1") {
+		t.Errorf("dump of nvme1n1 has metrics of nvme0n1:\n%s", out.String())
+	}
+
+	out.Reset()
+
+	collector = testCliCollector(t, outputs)
+	collector.device = devicePath("/dev/nvme7n1")
+
+	if code := writeMetrics(&out, collector); code != 1 || out.Len() != 0 {
+		t.Errorf("dump of an unknown device returned %d and printed %q, want 1 and nothing", code, out.String())
+	}
+}
+
+func TestCheckDevices(t *testing.T) {
+	for name, test := range map[string]struct {
+		outputs map[string]string
+		want    []checkResult
+	}{
+		"all readable": {
+			outputs: map[string]string{"list": testDeviceList, "smart-log": `{"temperature":310}`},
+			want: []checkResult{
+				{exitOK, "/dev/nvme0n1: log pages available"},
+				{exitOK, "/dev/nvme1n1: log pages available"},
+			},
+		},
+		"smart log failing": {
+			outputs: map[string]string{"list": testDeviceList, "smart-log /dev/nvme0n1": `{"temperature":310}`},
+			want: []checkResult{
+				{exitOK, "/dev/nvme0n1: log pages available"},
+				{exitWarning, "/dev/nvme1n1: error running smart-log /dev/nvme1n1 -o json: exit status 1"},
+			},
+		},
+		"no devices": {
+			outputs: map[string]string{"list": `{"Devices":[]}`},
+			want:    []checkResult{{exitWarning, "no NVMe devices found"}},
+		},
+		"list failing": {
+			want: []checkResult{{exitCritical, "error running nvme list -o json: exit status 1"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got := checkDevices(testCliCollector(t, test.outputs))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("checkDevices() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteCheckResults(t *testing.T) {
+	for _, test := range []struct {
+		statuses []int
+		want     int
+	}{
+		{nil, exitOK},
+		{[]int{exitOK, exitOK}, exitOK},
+		{[]int{exitOK, exitWarning}, exitWarning},
+		{[]int{exitWarning, exitCritical, exitOK}, exitCritical},
+		{[]int{exitOK, exitUnknown}, exitUnknown},
+		{[]int{exitUnknown, exitWarning}, exitWarning},
+		{[]int{exitCritical, exitUnknown}, exitCritical},
+	} {
+		results := make([]checkResult, 0, len(test.statuses))
+		for _, status := range test.statuses {
+			results = append(results, checkResult{status, "message"})
+		}
+
+		var out bytes.Buffer
+		if got := writeCheckResults(&out, results); got != test.want {
+			t.Errorf("exit code of %v = %d, want %d", test.statuses, got, test.want)
+		}
+
+		if header := "NVME " + _statusNames[test.want] + "\n"; !strings.HasPrefix(out.String(), header) {
+			t.Errorf("report of %v starts with %q, want %q", test.statuses, out.String(), header)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// diskSectorSize is the unit of the /proc/diskstats sector counts,
+	// regardless of the device block size.
+	diskSectorSize = 512
+	// diskstatsFirstField is the index of the first counter after major, minor and name.
+	diskstatsFirstField = 3
+	millisecondsPerSec  = 1000
+)
+
+// _namespaceRe matches NVMe namespace block devices, skipping partitions and
+// the hidden per-path devices of multipath namespaces.
+var _namespaceRe = regexp.MustCompile(`^nvme\d+n\d+$`)
+
+// diskstatsField describes one /proc/diskstats counter, see
+// Documentation/admin-guide/iostats.rst. Scale converts it to base units.
+type diskstatsField struct {
+	name  string
+	help  string
+	scale float64
+	kind  prometheus.ValueType
+}
+
+// _diskstatsFields are in /proc/diskstats column order. Older kernels report
+// fewer columns, the missing ones are skipped.
+var _diskstatsFields = []diskstatsField{
+	{"reads_completed_total", "Number of reads completed successfully", 1, prometheus.CounterValue},
+	{"reads_merged_total", "Number of adjacent reads merged", 1, prometheus.CounterValue},
+	{"read_bytes_total", "Number of bytes read", diskSectorSize, prometheus.CounterValue},
+	{"read_time_seconds_total", "Time spent on reads", 1.0 / millisecondsPerSec, prometheus.CounterValue},
+	{"writes_completed_total", "Number of writes completed successfully", 1, prometheus.CounterValue},
+	{"writes_merged_total", "Number of adjacent writes merged", 1, prometheus.CounterValue},
+	{"written_bytes_total", "Number of bytes written", diskSectorSize, prometheus.CounterValue},
+	{"write_time_seconds_total", "Time spent on writes", 1.0 / millisecondsPerSec, prometheus.CounterValue},
+	{"io_now", "Number of I/Os currently in progress", 1, prometheus.GaugeValue},
+	{"io_time_seconds_total", "Time spent doing I/Os", 1.0 / millisecondsPerSec, prometheus.CounterValue},
+	{"io_time_weighted_seconds_total", "Weighted time spent doing I/Os", 1.0 / millisecondsPerSec,
+		prometheus.CounterValue},
+	{"discards_completed_total", "Number of discards completed successfully", 1, prometheus.CounterValue},
+	{"discards_merged_total", "Number of adjacent discards merged", 1, prometheus.CounterValue},
+	{"discarded_bytes_total", "Number of bytes discarded", diskSectorSize, prometheus.CounterValue},
+	{"discard_time_seconds_total", "Time spent on discards", 1.0 / millisecondsPerSec, prometheus.CounterValue},
+	{"flush_requests_total", "Number of flush requests completed successfully", 1, prometheus.CounterValue},
+	{"flush_requests_time_seconds_total", "Time spent on flushes", 1.0 / millisecondsPerSec, prometheus.CounterValue},
+}
+
+// diskstatsCollector exports block layer I/O statistics and queue settings of
+// NVMe namespaces, labelled like the smart-log metrics so they can be joined.
+type diskstatsCollector struct {
+	procRoot                   string
+	identity                   identityConfig
+	nvmeBlockStats             []*prometheus.Desc
+	nvmeBlockQueueInfo         *prometheus.Desc
+	nvmeBlockQueueNrRequests   *prometheus.Desc
+	nvmeBlockLogicalBlockSize  *prometheus.Desc
+	nvmeBlockPhysicalBlockSize *prometheus.Desc
+}
+
+func newDiskstatsCollector(procRoot string, identity identityConfig) *diskstatsCollector {
+	labels := identity.labelNames()
+
+	stats := make([]*prometheus.Desc, 0, len(_diskstatsFields))
+	for _, field := range _diskstatsFields {
+		stats = append(stats, prometheus.NewDesc("nvme_block_"+field.name, field.help, labels, nil))
+	}
+
+	return &diskstatsCollector{
+		procRoot:       procRoot,
+		identity:       identity,
+		nvmeBlockStats: stats,
+		nvmeBlockQueueInfo: prometheus.NewDesc(
+			"nvme_block_queue_info",
+			"Block queue I/O scheduler and write cache mode",
+			withLabels(labels, "scheduler", "write_cache"),
+			nil,
+		),
+		nvmeBlockQueueNrRequests: prometheus.NewDesc(
+			"nvme_block_queue_nr_requests",
+			"Maximum number of requests queued in the block layer",
+			labels,
+			nil,
+		),
+		nvmeBlockLogicalBlockSize: prometheus.NewDesc(
+			"nvme_block_queue_logical_block_size_bytes",
+			"Logical block size of the namespace",
+			labels,
+			nil,
+		),
+		nvmeBlockPhysicalBlockSize: prometheus.NewDesc(
+			"nvme_block_queue_physical_block_size_bytes",
+			"Physical block size of the namespace",
+			labels,
+			nil,
+		),
+	}
+}
+
+func (c *diskstatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.nvmeBlockStats {
+		ch <- desc
+	}
+
+	ch <- c.nvmeBlockQueueInfo
+	ch <- c.nvmeBlockQueueNrRequests
+	ch <- c.nvmeBlockLogicalBlockSize
+	ch <- c.nvmeBlockPhysicalBlockSize
+}
+
+// activeScheduler returns the bracketed entry of a queue/scheduler attribute
+// such as "[none] mq-deadline".
+func activeScheduler(schedulers string) string {
+	_, active, found := strings.Cut(schedulers, "[")
+	if !found {
+		return schedulers
+	}
+
+	active, _, _ = strings.Cut(active, "]")
+
+	return active
+}
+
+func (c *diskstatsCollector) Collect(ch chan<- prometheus.Metric) {
+	diskstats, err := os.Open(filepath.Join(c.procRoot, "diskstats"))
+	if err != nil {
+		log.Printf("Error reading diskstats: %s\n", err)
+
+		return
+	}
+	defer diskstats.Close()
+
+	scanner := bufio.NewScanner(diskstats)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) <= diskstatsFirstField || !_namespaceRe.MatchString(fields[2]) {
+			continue
+		}
+
+		name := fields[2]
+		labels := c.identity.namespaceLabels(name)
+
+		for i, value := range fields[diskstatsFirstField:] {
+			if i >= len(_diskstatsFields) {
+				break
+			}
+
+			counter, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.nvmeBlockStats[i], _diskstatsFields[i].kind, counter*_diskstatsFields[i].scale, labels...)
+		}
+
+		c.sendQueue(ch, filepath.Join(c.identity.sysRoot, "block", name, "queue"), labels)
+	}
+}
+
+func (c *diskstatsCollector) sendQueue(ch chan<- prometheus.Metric, queue string, labels []string) {
+	ch <- prometheus.MustNewConstMetric(c.nvmeBlockQueueInfo, prometheus.GaugeValue, 1, withLabels(labels,
+		activeScheduler(readSysfs(filepath.Join(queue, "scheduler"))),
+		readSysfs(filepath.Join(queue, "write_cache")))...)
+
+	if nrRequests, ok := readSysfsFloat(filepath.Join(queue, "nr_requests")); ok {
+		ch <- prometheus.MustNewConstMetric(c.nvmeBlockQueueNrRequests, prometheus.GaugeValue, nrRequests, labels...)
+	}
+
+	if size, ok := readSysfsFloat(filepath.Join(queue, "logical_block_size")); ok {
+		ch <- prometheus.MustNewConstMetric(c.nvmeBlockLogicalBlockSize, prometheus.GaugeValue, size, labels...)
+	}
+
+	if size, ok := readSysfsFloat(filepath.Join(queue, "physical_block_size")); ok {
+		ch <- prometheus.MustNewConstMetric(c.nvmeBlockPhysicalBlockSize, prometheus.GaugeValue, size, labels...)
+	}
+}
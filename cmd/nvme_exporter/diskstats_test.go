@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testDiskstats returns a procfs and a sysfs tree with a namespace, one of its
+// partitions and a SATA disk.
+func testDiskstats(t *testing.T) (string, identityConfig) {
+	t.Helper()
+
+	procRoot := t.TempDir()
+	writeTestFiles(t, procRoot, map[string]string{
+		"diskstats": "   8       0 sda 100 0 800 10 0 0 0 0 0 10 10 0 0 0 0 0 0\n" +
+			" 259       0 nvme0n1 2000 10 64000 1500 300 20 4096 250 1 1700 1750 5 0 80 2 40 3\n" +
+			" 259       1 nvme0n1p1 1000 0 32000 700 100 0 2048 100 0 800 800 0 0 0 0 0 0",
+	})
+
+	identity := testIdentity(t.TempDir())
+	writeTestFiles(t, identity.sysRoot, map[string]string{
+		"block/nvme0n1/nsid":                      "1",
+		"block/nvme0n1/eui":                       "00 25 38 8b 91 b0 e1 a2",
+		"block/nvme0n1/wwid":                      "eui.0025388b91b0e1a2",
+		"block/nvme0n1/device/serial":             "S64FNE0R800001  ",
+		"block/nvme0n1/queue/scheduler":           "[none] mq-deadline kyber",
+		"block/nvme0n1/queue/write_cache":         "write back",
+		"block/nvme0n1/queue/nr_requests":         "1023",
+		"block/nvme0n1/queue/logical_block_size":  "512",
+		"block/nvme0n1/queue/physical_block_size": "4096",
+	})
+
+	return procRoot, identity
+}
+
+func TestDiskstatsCollector(t *testing.T) {
+	procRoot, identity := testDiskstats(t)
+
+	expected := `
+# HELP nvme_block_read_bytes_total Number of bytes read
+# TYPE nvme_block_read_bytes_total counter
+nvme_block_read_bytes_total{device="/dev/nvme0n1"} 3.2768e+07
+# HELP nvme_block_io_now Number of I/Os currently in progress
+# TYPE nvme_block_io_now gauge
+nvme_block_io_now{device="/dev/nvme0n1"} 1
+# HELP nvme_block_flush_requests_time_seconds_total Time spent on flushes
+# TYPE nvme_block_flush_requests_time_seconds_total counter
+nvme_block_flush_requests_time_seconds_total{device="/dev/nvme0n1"} 0.003
+# HELP nvme_block_queue_info Block queue I/O scheduler and write cache mode
+# TYPE nvme_block_queue_info gauge
+nvme_block_queue_info{device="/dev/nvme0n1",scheduler="none",write_cache="write back"} 1
+# HELP nvme_block_queue_physical_block_size_bytes Physical block size of the namespace
+# TYPE nvme_block_queue_physical_block_size_bytes gauge
+nvme_block_queue_physical_block_size_bytes{device="/dev/nvme0n1"} 4096
+`
+
+	err := testutil.CollectAndCompare(newDiskstatsCollector(procRoot, identity), strings.NewReader(expected),
+		"nvme_block_read_bytes_total", "nvme_block_io_now", "nvme_block_flush_requests_time_seconds_total",
+		"nvme_block_queue_info", "nvme_block_queue_physical_block_size_bytes")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDiskstatsIdentityLabels(t *testing.T) {
+	procRoot, identity := testDiskstats(t)
+	identity.primary = identitySerial
+	identity.labels = true
+
+	expected := `
+# HELP nvme_block_queue_info Block queue I/O scheduler and write cache mode
+# TYPE nvme_block_queue_info gauge
+nvme_block_queue_info{by_id_path="",device="S64FNE0R800001",eui64="0025388b91b0e1a2",nguid="",scheduler="none",serial_number="S64FNE0R800001",uuid="",write_cache="write back"} 1
+# HELP nvme_block_writes_completed_total Number of writes completed successfully
+# TYPE nvme_block_writes_completed_total counter
+nvme_block_writes_completed_total{by_id_path="",device="S64FNE0R800001",eui64="0025388b91b0e1a2",nguid="",serial_number="S64FNE0R800001",uuid=""} 300
+`
+
+	err := testutil.CollectAndCompare(newDiskstatsCollector(procRoot, identity), strings.NewReader(expected),
+		"nvme_block_queue_info", "nvme_block_writes_completed_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestActiveScheduler(t *testing.T) {
+	for schedulers, want := range map[string]string{
+		"[none] mq-deadline kyber": "none",
+		"none [mq-deadline] kyber": "mq-deadline",
+		"none":                     "none",
+	} {
+		if got := activeScheduler(schedulers); got != want {
+			t.Errorf("activeScheduler(%q) = %q, want %q", schedulers, got, want)
+		}
+	}
+}
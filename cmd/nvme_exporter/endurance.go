@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ctrattEnduranceGroups is the CTRATT bit set by controllers supporting
+	// endurance groups.
+	ctrattEnduranceGroups = 1 << 4
+	// maxEnduranceGroups bounds the endurance-log commands per device and
+	// scrape, a controller can have up to 65535 endurance groups.
+	maxEnduranceGroups = 128
+)
+
+// enduranceField describes one endurance group log field, by its nvme-cli
+// endurance-log key, see the Endurance Group Information log page in the NVMe
+// base specification.
+type enduranceField struct {
+	key  string
+	name string
+	help string
+	kind prometheus.ValueType
+}
+
+var _enduranceFields = []enduranceField{
+	{"critical_warning", "critical_warning", "Critical warnings for the state of the endurance group",
+		prometheus.GaugeValue},
+	{"avl_spare", "avail_spare", "Normalized percentage of remaining spare capacity available",
+		prometheus.GaugeValue},
+	{"avl_spare_threshold", "avail_spare_threshold", "Available spare threshold, as a normalized percentage",
+		prometheus.GaugeValue},
+	{"percent_used", "percent_used", "Vendor specific estimate of the percentage of life used",
+		prometheus.GaugeValue},
+	{"endurance_estimate", "endurance_estimate", "Estimate of the data units that may be written over the life",
+		prometheus.GaugeValue},
+	{"data_units_read", "data_units_read_total", "Number of 512 byte data units read from the endurance group",
+		prometheus.CounterValue},
+	{"data_units_written", "data_units_written_total", "Number of 512 byte data units written by the host",
+		prometheus.CounterValue},
+	{"media_units_written", "media_units_written_total", "Number of 512 byte data units written to the media",
+		prometheus.CounterValue},
+	{"host_read_cmds", "host_read_commands_total", "Number of read commands completed", prometheus.CounterValue},
+	{"host_write_cmds", "host_write_commands_total", "Number of write commands completed", prometheus.CounterValue},
+	{"media_data_integrity_err", "media_data_integrity_errors_total",
+		"Number of unrecovered data integrity errors", prometheus.CounterValue},
+	{"num_err_info_log_entries", "error_log_entries_total", "Number of error information log entries",
+		prometheus.CounterValue},
+	{"total_end_grp_cap", "capacity_bytes", "Total capacity of the endurance group", prometheus.GaugeValue},
+	{"unalloc_end_grp_cap", "unallocated_capacity_bytes", "Unallocated capacity of the endurance group",
+		prometheus.GaugeValue},
+}
+
+// enduranceCollector exports the Endurance Group Information log page of
+// every endurance group. The groups rarely change, they are listed once per
+// controller, by serial number.
+type enduranceCollector struct {
+	mu                 sync.Mutex
+	groups             map[string][]int
+	nvmeEnduranceGroup []*prometheus.Desc
+}
+
+func newEnduranceCollector(labels []string) *enduranceCollector {
+	groupLabels := withLabels(labels, "endurance_group")
+
+	descs := make([]*prometheus.Desc, 0, len(_enduranceFields))
+	for _, field := range _enduranceFields {
+		descs = append(descs, prometheus.NewDesc("nvme_endurance_group_"+field.name, field.help, groupLabels, nil))
+	}
+
+	return &enduranceCollector{groups: map[string][]int{}, nvmeEnduranceGroup: descs}
+}
+
+// supportsEnduranceGroups reports whether id-ctrl ran and advertises
+// endurance groups.
+func supportsEnduranceGroups(device deviceSnapshot) bool {
+	idCtrl, ok := device.logs["id-ctrl"]
+	if !ok {
+		return false
+	}
+
+	ctratt, _ := logUint(idCtrl.Get("ctratt"))
+
+	return ctratt&ctrattEnduranceGroups != 0
+}
+
+// enduranceGroups returns the endurance group IDs of the controller, and false
+// when they are not known yet and the group list must be read. A failed list
+// is not cached, so it is read again on the next scrape.
+func (c *enduranceCollector) enduranceGroups(device deviceSnapshot) ([]int, bool) {
+	serial := device.info.Get("SerialNumber").String()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if groups, ok := c.groups[serial]; ok {
+		return groups, true
+	}
+
+	list, ok := device.logs["endurance-group-list"]
+	if !ok {
+		return nil, false
+	}
+
+	if !list.IsObject() {
+		return nil, true
+	}
+
+	groups := []int{}
+
+	for _, group := range list.Get("endgrp_list.#.endgrp_id").Array() {
+		if len(groups) == maxEnduranceGroups {
+			break
+		}
+
+		groups = append(groups, int(group.Int()))
+	}
+
+	c.groups[serial] = groups
+
+	return groups, true
+}
+
+func (c *enduranceCollector) queries(device deviceSnapshot) []string {
+	if !supportsEnduranceGroups(device) {
+		return []string{"id-ctrl"}
+	}
+
+	groups, ok := c.enduranceGroups(device)
+	if !ok {
+		return []string{"id-ctrl", "endurance-group-list"}
+	}
+
+	queries := []string{"id-ctrl"}
+	for _, group := range groups {
+		queries = append(queries, "endurance-log:"+strconv.Itoa(group))
+	}
+
+	return queries
+}
+
+func (c *enduranceCollector) describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.nvmeEnduranceGroup {
+		ch <- desc
+	}
+}
+
+func (c *enduranceCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	if !supportsEnduranceGroups(device) {
+		return
+	}
+
+	groups, _ := c.enduranceGroups(device)
+	for _, group := range groups {
+		groupID := strconv.Itoa(group)
+
+		enduranceLog := device.logs["endurance-log:"+groupID]
+		if !enduranceLog.IsObject() {
+			continue
+		}
+
+		for i, field := range _enduranceFields {
+			value := enduranceLog.Get(field.key)
+			if !value.Exists() {
+				continue
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				c.nvmeEnduranceGroup[i], field.kind, value.Float(), withLabels(labels, groupID)...)
+		}
+	}
+}
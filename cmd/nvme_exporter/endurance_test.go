@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// testEnduranceLog is the nvme-cli 2.x endurance-log -o json output of a
+// drive with a single endurance group, 128-bit counters are printed as
+// strings.
+const testEnduranceLog = `{
+  "critical_warning":0,
+  "endurance_group_features":0,
+  "avl_spare":100,
+  "avl_spare_threshold":10,
+  "percent_used":1,
+  "domain_identifier":0,
+  "endurance_estimate":"0",
+  "data_units_read":"114329745",
+  "data_units_written":"64293212",
+  "media_units_written":"82041558",
+  "host_read_cmds":"1903394730",
+  "host_write_cmds":"1170352129",
+  "media_data_integrity_err":"2",
+  "num_err_info_log_entries":"4",
+  "total_end_grp_cap":"7681501126656",
+  "unalloc_end_grp_cap":"0"
+}`
+
+func testEnduranceDevice(serial string, logs map[string]string) deviceSnapshot {
+	device := deviceSnapshot{
+		info: gjson.Parse(`{"DevicePath":"/dev/nvme0n1","SerialNumber":"` + serial + `"}`),
+		logs: map[string]gjson.Result{},
+	}
+
+	for query, output := range logs {
+		device.logs[query] = gjson.Parse(output)
+	}
+
+	return device
+}
+
+func TestEnduranceGroupDiscovery(t *testing.T) {
+	c := newEnduranceCollector([]string{"device"})
+
+	device := testEnduranceDevice("S1", nil)
+	if got := c.queries(device); !reflect.DeepEqual(got, []string{"id-ctrl"}) {
+		t.Fatalf("queries before id-ctrl = %v", got)
+	}
+
+	device.logs["id-ctrl"] = gjson.Parse(`{"ctratt":16,"endgidmax":1024}`)
+	if got := c.queries(device); !reflect.DeepEqual(got, []string{"id-ctrl", "endurance-group-list"}) {
+		t.Fatalf("queries before the group list = %v", got)
+	}
+
+	device.logs["endurance-group-list"] = gjson.Parse(
+		`{"num_endgrp_id":2,"endgrp_list":[{"endgrp_id":1},{"endgrp_id":3}]}`)
+	want := []string{"id-ctrl", "endurance-log:1", "endurance-log:3"}
+
+	if got := c.queries(device); !reflect.DeepEqual(got, want) {
+		t.Fatalf("queries after the group list = %v, want %v", got, want)
+	}
+
+	// Another namespace of the controller, or the next scrape, reuses the list.
+	next := testEnduranceDevice("S1", map[string]string{"id-ctrl": `{"ctratt":16}`})
+	if got := c.queries(next); !reflect.DeepEqual(got, want) {
+		t.Errorf("queries of the next scrape = %v, want %v", got, want)
+	}
+
+	other := testEnduranceDevice("S2", map[string]string{"id-ctrl": `{"ctratt":16}`})
+	if got := c.queries(other); !reflect.DeepEqual(got, []string{"id-ctrl", "endurance-group-list"}) {
+		t.Errorf("queries of another controller = %v", got)
+	}
+
+	unsupported := testEnduranceDevice("S3", map[string]string{"id-ctrl": `{"ctratt":0}`})
+	if got := c.queries(unsupported); !reflect.DeepEqual(got, []string{"id-ctrl"}) {
+		t.Errorf("queries without endurance groups = %v", got)
+	}
+}
+
+func TestEnduranceGroupListFailure(t *testing.T) {
+	c := newEnduranceCollector([]string{"device"})
+
+	device := testEnduranceDevice("S1", map[string]string{"id-ctrl": `{"ctratt":16}`, "endurance-group-list": ""})
+	if got := c.queries(device); !reflect.DeepEqual(got, []string{"id-ctrl"}) {
+		t.Errorf("queries after a failed group list = %v", got)
+	}
+
+	next := testEnduranceDevice("S1", map[string]string{"id-ctrl": `{"ctratt":16}`})
+	if got := c.queries(next); !reflect.DeepEqual(got, []string{"id-ctrl", "endurance-group-list"}) {
+		t.Errorf("failed group list was cached, queries = %v", got)
+	}
+}
+
+func TestEnduranceCollector(t *testing.T) {
+	collector := newEnduranceCollector([]string{"device"})
+	c := testLogPages{logPageCollector: collector, device: testEnduranceDevice("S1", map[string]string{
+		"id-ctrl":              `{"ctratt":16}`,
+		"endurance-group-list": `{"num_endgrp_id":1,"endgrp_list":[{"endgrp_id":1}]}`,
+		"endurance-log:1":      testEnduranceLog,
+	})}
+
+	expected := `
+# HELP nvme_endurance_group_avail_spare Normalized percentage of remaining spare capacity available
+# TYPE nvme_endurance_group_avail_spare gauge
+nvme_endurance_group_avail_spare{device="/dev/nvme0n1",endurance_group="1"} 100
+# HELP nvme_endurance_group_avail_spare_threshold Available spare threshold, as a normalized percentage
+# TYPE nvme_endurance_group_avail_spare_threshold gauge
+nvme_endurance_group_avail_spare_threshold{device="/dev/nvme0n1",endurance_group="1"} 10
+# HELP nvme_endurance_group_capacity_bytes Total capacity of the endurance group
+# TYPE nvme_endurance_group_capacity_bytes gauge
+nvme_endurance_group_capacity_bytes{device="/dev/nvme0n1",endurance_group="1"} 7.681501126656e+12
+# HELP nvme_endurance_group_data_units_read_total Number of 512 byte data units read from the endurance group
+# TYPE nvme_endurance_group_data_units_read_total counter
+nvme_endurance_group_data_units_read_total{device="/dev/nvme0n1",endurance_group="1"} 1.14329745e+08
+# HELP nvme_endurance_group_media_data_integrity_errors_total Number of unrecovered data integrity errors
+# TYPE nvme_endurance_group_media_data_integrity_errors_total counter
+nvme_endurance_group_media_data_integrity_errors_total{device="/dev/nvme0n1",endurance_group="1"} 2
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nvme_endurance_group_avail_spare", "nvme_endurance_group_avail_spare_threshold",
+		"nvme_endurance_group_capacity_bytes", "nvme_endurance_group_data_units_read_total",
+		"nvme_endurance_group_media_data_integrity_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if got := testutil.CollectAndCount(c); got != len(_enduranceFields) {
+		t.Errorf("collected %d metrics, want one per field (%d)", got, len(_enduranceFields))
+	}
+}
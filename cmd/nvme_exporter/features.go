@@ -0,0 +1,498 @@
+package main
+
+import (
+	"encoding/binary"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+// Feature identifiers, see the Get Features command in the NVMe base
+// specification.
+const (
+	featurePowerManagement    = 0x02
+	featureVolatileWriteCache = 0x06
+	featureNumberOfQueues     = 0x07
+	featureApst               = 0x0c
+	featureThermalManagement  = 0x10
+)
+
+const (
+	// powerStateMask selects the power state in the power management feature.
+	powerStateMask = 0x1f
+	// apstEntrySize is the size of an APST data structure entry, each holding
+	// the idle time (bits 31:8, in ms) and target state (bits 7:3).
+	apstEntrySize = 8
+	// psdPowerUnit and psdScaledPowerUnit are the power state descriptor max
+	// power units in watts, selected by the max power scale flag.
+	psdPowerUnit       = 0.01
+	psdScaledPowerUnit = 0.0001
+	microsecondsPerSec = 1e6
+	// temperatureSensors is the number of temperature sensors a controller may
+	// report besides the composite temperature.
+	temperatureSensors = 8
+	// thresholdSensorShift and thresholdUnder select the sensor and the under
+	// temperature threshold in the temperature threshold feature CDW11.
+	thresholdSensorShift = 16
+	thresholdUnder       = 1 << 20
+	// temperatureMask selects a temperature, in Kelvin, in feature values.
+	temperatureMask = 0xffff
+)
+
+// _featureValueRe matches the value nvme-cli prints for get-feature, such as
+// "get-feature:0x02 (Power Management), Current value:0x00000003".
+var _featureValueRe = regexp.MustCompile(`value:\s*(0x[0-9a-fA-F]+)`)
+
+// featureValue returns the Get Features completion dword of a feature query.
+func featureValue(output []byte) (uint64, bool) {
+	match := _featureValueRe.FindSubmatch(output)
+	if match == nil {
+		return 0, false
+	}
+
+	value, err := strconv.ParseUint(string(match[1]), 0, 32)
+
+	return value, err == nil
+}
+
+func featureQuery(feature int) string {
+	return "feature:" + strconv.Itoa(feature)
+}
+
+// psdFlag returns a power state descriptor flag, printed by nvme-cli either
+// as its own field or in the flags byte by older releases.
+func psdFlag(psd gjson.Result, key string, bit uint64) float64 {
+	if value, ok := logUint(psd.Get(key)); ok {
+		return float64(value)
+	}
+
+	flags, _ := logUint(psd.Get("flags"))
+
+	return float64(flags & bit / bit)
+}
+
+// isFeatureQuery reports whether a query reads a controller feature, kept
+// by featuresCollector.
+func isFeatureQuery(query string) bool {
+	return query == "apst-table" || strings.HasPrefix(query, "feature:") || isThresholdQuery(query)
+}
+
+func isThresholdQuery(query string) bool {
+	return strings.HasPrefix(query, "temperature-threshold:")
+}
+
+// controllerFeatures are the Get Features outputs of a controller, by query.
+type controllerFeatures struct {
+	// cycle is the collection cycle the features were read in.
+	cycle time.Time
+	// thresholdsRead is when the temperature thresholds were last read.
+	thresholdsRead time.Time
+	raw            map[string][]byte
+}
+
+// featuresCollector exports the power state descriptors and the current
+// power management, APST, write cache, temperature threshold and queue
+// settings of the controller. Features belong to the controller, they are
+// read once per cycle for all its namespaces, and the temperature thresholds,
+// only changed by the host, once per threshold refresh interval.
+type featuresCollector struct {
+	mu                         sync.Mutex
+	thresholdRefresh           time.Duration
+	controllers                map[string]*controllerFeatures
+	nvmePowerState             *prometheus.Desc
+	nvmePowerStateMaxPower     *prometheus.Desc
+	nvmePowerStateEntryLatency *prometheus.Desc
+	nvmePowerStateExitLatency  *prometheus.Desc
+	nvmePowerStateNonOperation *prometheus.Desc
+	nvmeApstEnabled            *prometheus.Desc
+	nvmeApstIdleTime           *prometheus.Desc
+	nvmeVolatileWriteCache     *prometheus.Desc
+	nvmeTemperatureThreshold   *prometheus.Desc
+	nvmeTemperatureWarning     *prometheus.Desc
+	nvmeTemperatureCritical    *prometheus.Desc
+	nvmeThermalManagement      *prometheus.Desc
+	nvmeTemperatureMargin      *prometheus.Desc
+	nvmeIoSubmissionQueues     *prometheus.Desc
+	nvmeIoCompletionQueues     *prometheus.Desc
+}
+
+// temperatureThreshold is a temperature threshold feature selector, the
+// sensor and threshold type passed in CDW11.
+type temperatureThreshold struct {
+	sensor    string
+	kind      string
+	selection int
+}
+
+func (t temperatureThreshold) query() string {
+	return "temperature-threshold:" + strconv.Itoa(t.selection)
+}
+
+// temperatureThresholds returns the over and under temperature thresholds of
+// the composite temperature and of every sensor in the SMART log.
+func temperatureThresholds(device deviceSnapshot) []temperatureThreshold {
+	thresholds := []temperatureThreshold{{"composite", "over", 0}, {"composite", "under", thresholdUnder}}
+
+	for sensor := 1; sensor <= temperatureSensors; sensor++ {
+		if !device.smartLog.Get("temperature_sensor_" + strconv.Itoa(sensor)).Exists() {
+			continue
+		}
+
+		selection := sensor << thresholdSensorShift
+		thresholds = append(thresholds,
+			temperatureThreshold{strconv.Itoa(sensor), "over", selection},
+			temperatureThreshold{strconv.Itoa(sensor), "under", selection | thresholdUnder})
+	}
+
+	return thresholds
+}
+
+func newFeaturesCollector(labels []string, thresholdRefresh time.Duration) *featuresCollector {
+	powerStateLabels := withLabels(labels, "power_state")
+
+	return &featuresCollector{
+		thresholdRefresh: thresholdRefresh,
+		controllers:      map[string]*controllerFeatures{},
+		nvmePowerState: prometheus.NewDesc(
+			"nvme_power_state",
+			"Current power state of the controller",
+			labels,
+			nil,
+		),
+		nvmePowerStateMaxPower: prometheus.NewDesc(
+			"nvme_power_state_max_power_watts",
+			"Maximum power consumed in the power state",
+			powerStateLabels,
+			nil,
+		),
+		nvmePowerStateEntryLatency: prometheus.NewDesc(
+			"nvme_power_state_entry_latency_seconds",
+			"Maximum latency to enter the power state",
+			powerStateLabels,
+			nil,
+		),
+		nvmePowerStateExitLatency: prometheus.NewDesc(
+			"nvme_power_state_exit_latency_seconds",
+			"Maximum latency to exit the power state",
+			powerStateLabels,
+			nil,
+		),
+		nvmePowerStateNonOperation: prometheus.NewDesc(
+			"nvme_power_state_non_operational",
+			"Whether the power state is non-operational, the controller processes no I/O in it",
+			powerStateLabels,
+			nil,
+		),
+		nvmeApstEnabled: prometheus.NewDesc(
+			"nvme_apst_enabled",
+			"Whether autonomous power state transitions are enabled",
+			labels,
+			nil,
+		),
+		nvmeApstIdleTime: prometheus.NewDesc(
+			"nvme_apst_idle_time_seconds",
+			"Idle time after which the controller autonomously moves from the power state to the target state",
+			withLabels(labels, "power_state", "target_power_state"),
+			nil,
+		),
+		nvmeVolatileWriteCache: prometheus.NewDesc(
+			"nvme_volatile_write_cache_enabled",
+			"Whether the volatile write cache is enabled",
+			labels,
+			nil,
+		),
+		nvmeTemperatureThreshold: prometheus.NewDesc(
+			"nvme_temperature_threshold_celsius",
+			"Temperature threshold set by the host, raising an asynchronous event when crossed",
+			withLabels(labels, "sensor", "type"),
+			nil,
+		),
+		nvmeTemperatureWarning: prometheus.NewDesc(
+			"nvme_temperature_warning_threshold_celsius",
+			"Composite temperature above which the controller reports a warning and counts the warning temperature time",
+			labels,
+			nil,
+		),
+		nvmeTemperatureCritical: prometheus.NewDesc(
+			"nvme_temperature_critical_threshold_celsius",
+			"Composite temperature above which the controller may stop operating and counts the critical "+
+				"temperature time",
+			labels,
+			nil,
+		),
+		nvmeThermalManagement: prometheus.NewDesc(
+			"nvme_thermal_management_temperature_celsius",
+			"Composite temperature above which the controller throttles, at light (level 1) or heavy (level 2) "+
+				"performance impact",
+			withLabels(labels, "level"),
+			nil,
+		),
+		nvmeTemperatureMargin: prometheus.NewDesc(
+			"nvme_temperature_margin_celsius",
+			"Distance of the composite temperature to the nearest warning, critical, over or thermal "+
+				"management threshold, negative once crossed",
+			labels,
+			nil,
+		),
+		nvmeIoSubmissionQueues: prometheus.NewDesc(
+			"nvme_io_submission_queues",
+			"Number of I/O submission queues allocated by the controller",
+			labels,
+			nil,
+		),
+		nvmeIoCompletionQueues: prometheus.NewDesc(
+			"nvme_io_completion_queues",
+			"Number of I/O completion queues allocated by the controller",
+			labels,
+			nil,
+		),
+	}
+}
+
+func (c *featuresCollector) queries(device deviceSnapshot) []string {
+	queries := []string{"id-ctrl"}
+
+	c.mu.Lock()
+	controller := c.controllers[device.info.Get("SerialNumber").String()]
+	c.mu.Unlock()
+
+	if controller != nil && controller.cycle.Equal(device.cycle) {
+		return queries
+	}
+
+	queries = append(queries, featureQuery(featurePowerManagement), featureQuery(featureNumberOfQueues))
+
+	if controller == nil || device.cycle.Sub(controller.thresholdsRead) >= c.thresholdRefresh {
+		for _, threshold := range temperatureThresholds(device) {
+			queries = append(queries, threshold.query())
+		}
+	}
+
+	idCtrl, ok := device.logs["id-ctrl"]
+	if !ok {
+		return queries
+	}
+
+	if apsta, _ := logUint(idCtrl.Get("apsta")); apsta&1 != 0 {
+		queries = append(queries, featureQuery(featureApst), "apst-table")
+	}
+
+	if vwc, _ := logUint(idCtrl.Get("vwc")); vwc&1 != 0 {
+		queries = append(queries, featureQuery(featureVolatileWriteCache))
+	}
+
+	if hctma, _ := logUint(idCtrl.Get("hctma")); hctma&1 != 0 {
+		queries = append(queries, featureQuery(featureThermalManagement))
+	}
+
+	return queries
+}
+
+// update stores the features read for the device as those of its
+// controller. The thresholds are only kept once all of them were read.
+func (c *featuresCollector) update(device deviceSnapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	serial := device.info.Get("SerialNumber").String()
+
+	controller := c.controllers[serial]
+	if controller == nil {
+		controller = &controllerFeatures{raw: map[string][]byte{}}
+		c.controllers[serial] = controller
+	}
+
+	if controller.cycle.Equal(device.cycle) {
+		return
+	}
+
+	controller.cycle = device.cycle
+	thresholds := true
+
+	for _, threshold := range temperatureThresholds(device) {
+		if _, ok := featureValue(device.raw[threshold.query()]); !ok {
+			thresholds = false
+		}
+	}
+
+	// The map is replaced rather than modified, send may still be reading it.
+	raw := map[string][]byte{}
+
+	for query, output := range controller.raw {
+		if isThresholdQuery(query) && !thresholds {
+			raw[query] = output
+		}
+	}
+
+	for query, output := range device.raw {
+		if isFeatureQuery(query) && (thresholds || !isThresholdQuery(query)) {
+			raw[query] = output
+		}
+	}
+
+	controller.raw = raw
+
+	if thresholds {
+		controller.thresholdsRead = device.cycle
+	}
+}
+
+// features returns the Get Features outputs of the controller of the device.
+func (c *featuresCollector) features(device deviceSnapshot) map[string][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	controller := c.controllers[device.info.Get("SerialNumber").String()]
+	if controller == nil {
+		return device.raw
+	}
+
+	return controller.raw
+}
+
+func (c *featuresCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmePowerState
+	ch <- c.nvmePowerStateMaxPower
+	ch <- c.nvmePowerStateEntryLatency
+	ch <- c.nvmePowerStateExitLatency
+	ch <- c.nvmePowerStateNonOperation
+	ch <- c.nvmeApstEnabled
+	ch <- c.nvmeApstIdleTime
+	ch <- c.nvmeVolatileWriteCache
+	ch <- c.nvmeTemperatureThreshold
+	ch <- c.nvmeTemperatureWarning
+	ch <- c.nvmeTemperatureCritical
+	ch <- c.nvmeThermalManagement
+	ch <- c.nvmeTemperatureMargin
+	ch <- c.nvmeIoSubmissionQueues
+	ch <- c.nvmeIoCompletionQueues
+}
+
+func (c *featuresCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	raw := c.features(device)
+
+	c.sendPowerStates(ch, device, raw, labels)
+	c.sendTemperatures(ch, device, raw, labels)
+
+	if value, ok := featureValue(raw[featureQuery(featurePowerManagement)]); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmePowerState, prometheus.GaugeValue, float64(value&powerStateMask), labels...)
+	}
+
+	if value, ok := featureValue(raw[featureQuery(featureApst)]); ok {
+		ch <- prometheus.MustNewConstMetric(c.nvmeApstEnabled, prometheus.GaugeValue, float64(value&1), labels...)
+	}
+
+	if value, ok := featureValue(raw[featureQuery(featureVolatileWriteCache)]); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeVolatileWriteCache, prometheus.GaugeValue, float64(value&1), labels...)
+	}
+
+	// Both counts are zero-based.
+	if value, ok := featureValue(raw[featureQuery(featureNumberOfQueues)]); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeIoSubmissionQueues, prometheus.GaugeValue, float64(value&0xffff+1), labels...)
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeIoCompletionQueues, prometheus.GaugeValue, float64(value>>16+1), labels...)
+	}
+}
+
+// sendTemperatures exports the controller and host temperature thresholds,
+// and the margin of the composite temperature to the lowest one it must stay
+// below. Thresholds are in Kelvin, 0 when not reported or disabled.
+func (c *featuresCollector) sendTemperatures(
+	ch chan<- prometheus.Metric, device deviceSnapshot, raw map[string][]byte, labels []string,
+) {
+	var limits []uint64
+
+	idCtrl := device.logs["id-ctrl"]
+	if warning, _ := logUint(idCtrl.Get("wctemp")); warning != 0 {
+		limits = append(limits, warning)
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeTemperatureWarning, prometheus.GaugeValue, float64(warning)-kelvinOffset, labels...)
+	}
+
+	if critical, _ := logUint(idCtrl.Get("cctemp")); critical != 0 {
+		limits = append(limits, critical)
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeTemperatureCritical, prometheus.GaugeValue, float64(critical)-kelvinOffset, labels...)
+	}
+
+	for _, threshold := range temperatureThresholds(device) {
+		// A threshold of 0 K is disabled or not reported by the controller.
+		value, ok := featureValue(raw[threshold.query()])
+		if !ok || value&temperatureMask == 0 {
+			continue
+		}
+
+		if threshold.selection == 0 {
+			limits = append(limits, value&temperatureMask)
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.nvmeTemperatureThreshold, prometheus.GaugeValue,
+			float64(value&temperatureMask)-kelvinOffset, withLabels(labels, threshold.sensor, threshold.kind)...)
+	}
+
+	// TMT1 is in bits 31:16, TMT2 in bits 15:0.
+	if value, ok := featureValue(raw[featureQuery(featureThermalManagement)]); ok {
+		for level, temperature := range []uint64{value >> 16, value & temperatureMask} {
+			if temperature == 0 {
+				continue
+			}
+
+			limits = append(limits, temperature)
+			ch <- prometheus.MustNewConstMetric(c.nvmeThermalManagement, prometheus.GaugeValue,
+				float64(temperature)-kelvinOffset, withLabels(labels, strconv.Itoa(level+1))...)
+		}
+	}
+
+	temperature, ok := logUint(device.smartLog.Get("temperature"))
+	if !ok || len(limits) == 0 {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.nvmeTemperatureMargin, prometheus.GaugeValue, float64(slices.Min(limits))-float64(temperature), labels...)
+}
+
+// sendPowerStates exports the id-ctrl power state descriptors and the APST
+// transitions configured for them.
+func (c *featuresCollector) sendPowerStates(
+	ch chan<- prometheus.Metric, device deviceSnapshot, raw map[string][]byte, labels []string,
+) {
+	psds := device.logs["id-ctrl"].Get("psds").Array()
+
+	for powerState, psd := range psds {
+		stateLabels := withLabels(labels, strconv.Itoa(powerState))
+
+		unit := psdPowerUnit
+		if psdFlag(psd, "max_power_scale", 1) == 1 {
+			unit = psdScaledPowerUnit
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmePowerStateMaxPower, prometheus.GaugeValue, psd.Get("max_power").Float()*unit, stateLabels...)
+		ch <- prometheus.MustNewConstMetric(c.nvmePowerStateEntryLatency, prometheus.GaugeValue,
+			psd.Get("entry_lat").Float()/microsecondsPerSec, stateLabels...)
+		ch <- prometheus.MustNewConstMetric(c.nvmePowerStateExitLatency, prometheus.GaugeValue,
+			psd.Get("exit_lat").Float()/microsecondsPerSec, stateLabels...)
+		ch <- prometheus.MustNewConstMetric(c.nvmePowerStateNonOperation, prometheus.GaugeValue,
+			psdFlag(psd, "non-operational_state", 2), stateLabels...)
+	}
+
+	table := raw["apst-table"]
+	for powerState := 0; powerState < len(psds) && (powerState+1)*apstEntrySize <= len(table); powerState++ {
+		entry := binary.LittleEndian.Uint32(table[powerState*apstEntrySize:])
+		if idleTime := entry >> 8; idleTime != 0 {
+			ch <- prometheus.MustNewConstMetric(c.nvmeApstIdleTime, prometheus.GaugeValue,
+				float64(idleTime)/millisecondsPerSec,
+				withLabels(labels, strconv.Itoa(powerState), strconv.Itoa(int(entry>>3&powerStateMask)))...)
+		}
+	}
+}
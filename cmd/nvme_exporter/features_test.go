@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// testFeatureOutputs are the nvme-cli outputs of a controller with one
+// temperature sensor and a volatile write cache.
+var testFeatureOutputs = map[string]string{
+	"smart-log":                     `{"temperature":318,"temperature_sensor_1":320}`,
+	"id-ctrl":                       `{"vwc":1,"wctemp":353,"cctemp":358}`,
+	"feature:2":                     "get-feature:0x02 (Power Management), Current value:0x00000003",
+	"feature:6":                     "get-feature:0x06 (Volatile Write Cache), Current value:0x00000001",
+	"feature:7":                     "get-feature:0x07 (Number of Queues), Current value:0x003f003f",
+	"temperature-threshold:0":       "get-feature:0x04 (Temperature Threshold), Current value:0x0000015b",
+	"temperature-threshold:1048576": "get-feature:0x04 (Temperature Threshold), Current value:0x00000111",
+	"temperature-threshold:65536":   "get-feature:0x04 (Temperature Threshold), Current value:0x0000015d",
+	"temperature-threshold:1114112": "get-feature:0x04 (Temperature Threshold), Current value:0x00000000",
+}
+
+func TestFeaturesPerController(t *testing.T) {
+	var ran []string
+
+	features := newFeaturesCollector([]string{"device"}, 10*time.Minute)
+	collector := newNvmeCollector(false, testIdentity(t.TempDir()))
+	collector.logPages = []logPageCollector{features}
+	collector.run = func(query, _ string) ([]byte, error) {
+		ran = append(ran, query)
+
+		return []byte(testFeatureOutputs[query]), nil
+	}
+
+	namespaces := []gjson.Result{
+		gjson.Parse(`{"DevicePath":"/dev/nvme0n1","SerialNumber":"S1"}`),
+		gjson.Parse(`{"DevicePath":"/dev/nvme0n2","SerialNumber":"S1"}`),
+	}
+	collect := func(cycle time.Time) []deviceSnapshot {
+		ran = nil
+
+		return []deviceSnapshot{
+			collector.collectDevice(namespaces[0], cycle), collector.collectDevice(namespaces[1], cycle),
+		}
+	}
+	count := func(prefix string) int {
+		return len(slices.DeleteFunc(slices.Clone(ran), func(query string) bool {
+			return !strings.HasPrefix(query, prefix)
+		}))
+	}
+
+	start := time.Now()
+	devices := collect(start)
+
+	if got := count("feature:"); got != 3 {
+		t.Errorf("ran %d feature queries for two namespaces, want 3 for the controller", got)
+	}
+
+	if got := count("temperature-threshold:"); got != 4 {
+		t.Errorf("ran %d temperature threshold queries, want 4", got)
+	}
+
+	// The second namespace did not read the features, it sends those of the
+	// controller. The disabled under threshold of sensor 1 is left out.
+	expected := `
+# HELP nvme_power_state Current power state of the controller
+# TYPE nvme_power_state gauge
+nvme_power_state{device="/dev/nvme0n1"} 3
+# HELP nvme_temperature_threshold_celsius Temperature threshold set by the host, raising an asynchronous event when crossed
+# TYPE nvme_temperature_threshold_celsius gauge
+nvme_temperature_threshold_celsius{device="/dev/nvme0n1",sensor="1",type="over"} 76
+nvme_temperature_threshold_celsius{device="/dev/nvme0n1",sensor="composite",type="over"} 74
+nvme_temperature_threshold_celsius{device="/dev/nvme0n1",sensor="composite",type="under"} 0
+# HELP nvme_temperature_margin_celsius Distance of the composite temperature to the nearest warning, critical, over or thermal management threshold, negative once crossed
+# TYPE nvme_temperature_margin_celsius gauge
+nvme_temperature_margin_celsius{device="/dev/nvme0n1"} 29
+# HELP nvme_io_submission_queues Number of I/O submission queues allocated by the controller
+# TYPE nvme_io_submission_queues gauge
+nvme_io_submission_queues{device="/dev/nvme0n1"} 64
+`
+
+	c := testLogPages{logPageCollector: features, device: devices[1]}
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nvme_power_state", "nvme_temperature_threshold_celsius", "nvme_temperature_margin_celsius",
+		"nvme_io_submission_queues")
+	if err != nil {
+		t.Error(err)
+	}
+
+	collect(start.Add(time.Minute))
+
+	if count("feature:") != 3 || count("temperature-threshold:") != 0 {
+		t.Errorf("ran %v in the next cycle, want the features without the thresholds", ran)
+	}
+
+	collect(start.Add(10 * time.Minute))
+
+	if got := count("temperature-threshold:"); got != 4 {
+		t.Errorf("ran %d temperature threshold queries after the refresh interval, want 4", got)
+	}
+}
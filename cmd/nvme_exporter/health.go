@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// kelvinOffset converts the smart-log composite temperature, reported in
+// Kelvin, to degrees Celsius.
+const kelvinOffset = 273
+
+// threshold holds the warning and critical levels for a single health check.
+type threshold struct {
+	Warning  float64 `json:"warning"`
+	Critical float64 `json:"critical"`
+}
+
+// healthThresholds are the levels check-health evaluates a device against.
+// Unset fields in a per-model override keep the default value.
+type healthThresholds struct {
+	Temperature *threshold `json:"temperature,omitempty"`
+	PercentUsed *threshold `json:"percent_used,omitempty"`
+	MediaErrors *threshold `json:"media_errors,omitempty"`
+	AvailSpare  *threshold `json:"avail_spare,omitempty"`
+}
+
+// healthConfig is the check-health configuration file. Models maps an exact
+// model number, as reported by nvme list, to its threshold overrides.
+type healthConfig struct {
+	Thresholds healthThresholds            `json:"thresholds"`
+	Models     map[string]healthThresholds `json:"models"`
+}
+
+var _defaultHealthThresholds = healthThresholds{
+	Temperature: &threshold{Warning: 70, Critical: 80},
+	PercentUsed: &threshold{Warning: 80, Critical: 90},
+	MediaErrors: &threshold{Warning: 0, Critical: 100},
+	AvailSpare:  &threshold{Warning: 20, Critical: 10},
+}
+
+// merge returns t with every field set in override replaced.
+func (t healthThresholds) merge(override healthThresholds) healthThresholds {
+	if override.Temperature != nil {
+		t.Temperature = override.Temperature
+	}
+
+	if override.PercentUsed != nil {
+		t.PercentUsed = override.PercentUsed
+	}
+
+	if override.MediaErrors != nil {
+		t.MediaErrors = override.MediaErrors
+	}
+
+	if override.AvailSpare != nil {
+		t.AvailSpare = override.AvailSpare
+	}
+
+	return t
+}
+
+func loadHealthConfig(path string) (healthConfig, error) {
+	config := healthConfig{}
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return config, fmt.Errorf("error reading health config: %w", err)
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return config, fmt.Errorf("error parsing health config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// thresholdsFor returns the thresholds that apply to the given model number.
+func (c healthConfig) thresholdsFor(model string) healthThresholds {
+	thresholds := _defaultHealthThresholds.merge(c.Thresholds)
+	if override, ok := c.Models[strings.TrimSpace(model)]; ok {
+		thresholds = thresholds.merge(override)
+	}
+
+	return thresholds
+}
+
+// healthReport accumulates plugin status, messages and perfdata.
+type healthReport struct {
+	status   int
+	messages []string
+	perfdata []string
+}
+
+func (r *healthReport) add(status int, message string) {
+	r.status = worseStatus(r.status, status)
+	r.messages = append(r.messages, message)
+}
+
+// above checks a value that is unhealthy when it exceeds the threshold.
+func (r *healthReport) above(name, label, unit string, value float64, limits *threshold) {
+	r.perfdata = append(r.perfdata,
+		fmt.Sprintf("'%s_%s'=%g%s;%g;%g", name, label, value, unit, limits.Warning, limits.Critical))
+
+	switch {
+	case value > limits.Critical:
+		r.add(exitCritical, fmt.Sprintf("%s %s %g%s > %g%s", name, label, value, unit, limits.Critical, unit))
+	case value > limits.Warning:
+		r.add(exitWarning, fmt.Sprintf("%s %s %g%s > %g%s", name, label, value, unit, limits.Warning, unit))
+	}
+}
+
+// below checks a value that is unhealthy when it drops under the threshold.
+func (r *healthReport) below(name, label, unit string, value float64, limits *threshold) {
+	r.perfdata = append(r.perfdata,
+		fmt.Sprintf("'%s_%s'=%g%s;%g:;%g:", name, label, value, unit, limits.Warning, limits.Critical))
+
+	switch {
+	case value < limits.Critical:
+		r.add(exitCritical, fmt.Sprintf("%s %s %g%s < %g%s", name, label, value, unit, limits.Critical, unit))
+	case value < limits.Warning:
+		r.add(exitWarning, fmt.Sprintf("%s %s %g%s < %g%s", name, label, value, unit, limits.Warning, unit))
+	}
+}
+
+func (r *healthReport) evaluate(device deviceSnapshot, thresholds healthThresholds) {
+	name := filepath.Base(device.info.Get("DevicePath").String())
+	if !device.smartLog.Exists() {
+		r.add(exitUnknown, name+" smart-log unavailable")
+
+		return
+	}
+
+	smartLog := device.smartLog
+	if criticalWarning := smartLog.Get("critical_warning").Int(); criticalWarning != 0 {
+		r.add(exitCritical, fmt.Sprintf("%s critical_warning 0x%x", name, criticalWarning))
+	}
+
+	availSpare := smartLog.Get("avail_spare").Float()
+	if spareThresh := smartLog.Get("spare_thresh").Float(); availSpare < spareThresh {
+		r.add(exitCritical, fmt.Sprintf("%s avail_spare %g%% < spare_thresh %g%%", name, availSpare, spareThresh))
+	}
+
+	r.below(name, "avail_spare", "%", availSpare, thresholds.AvailSpare)
+	r.above(name, "percent_used", "%", smartLog.Get("percent_used").Float(), thresholds.PercentUsed)
+	r.above(name, "media_errors", "c", smartLog.Get("media_errors").Float(), thresholds.MediaErrors)
+	r.above(name, "temperature", "", smartLog.Get("temperature").Float()-kelvinOffset, thresholds.Temperature)
+}
+
+func runCheckHealth(args []string) int {
+	flags := flag.NewFlagSet("check-health", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to a JSON file with threshold defaults and per-model overrides")
+	flags.Usage = func() {
+		fmt.Println("Usage: nvme_exporter check-health [options] [device]")
+		fmt.Println("Evaluates device health against thresholds as a Nagios/Icinga plugin.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	config, err := loadHealthConfig(*configFile)
+	if err != nil {
+		fmt.Printf("NVME UNKNOWN - %s\n", err)
+
+		return exitUnknown
+	}
+
+	collector := newNvmeCollector(false, _defaultIdentity)
+	collector.device = devicePath(flags.Arg(0))
+
+	return checkHealth(os.Stdout, collector, config)
+}
+
+// checkHealth collects the devices once and prints the plugin output. The
+// SMART log belongs to the controller, so a controller is evaluated once, by
+// its first namespace.
+func checkHealth(out io.Writer, collector *nvmeCollector, config healthConfig) int {
+	snap := collector.refresh()
+	if len(snap.errors) > 0 {
+		fmt.Fprintf(out, "NVME UNKNOWN - %s\n", strings.Join(snap.errors, ", "))
+
+		return exitUnknown
+	}
+
+	if len(snap.devices) == 0 {
+		fmt.Fprintln(out, "NVME UNKNOWN - no NVMe devices found")
+
+		return exitUnknown
+	}
+
+	report := &healthReport{}
+	controllers := map[string]bool{}
+
+	for _, device := range snap.devices {
+		serial := device.info.Get("SerialNumber").String()
+		if serial != "" && controllers[serial] {
+			continue
+		}
+
+		controllers[serial] = true
+
+		report.evaluate(device, config.thresholdsFor(device.info.Get("ModelNumber").String()))
+	}
+
+	summary := fmt.Sprintf("%d devices healthy", len(controllers))
+	if len(report.messages) > 0 {
+		summary = strings.Join(report.messages, ", ")
+	}
+
+	fmt.Fprintf(out, "NVME %s - %s | %s\n", _statusNames[report.status], summary, strings.Join(report.perfdata, " "))
+
+	return report.status
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestHealthThresholdsFor(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "health.json")
+
+	err := os.WriteFile(configFile, []byte(`{
+  "thresholds": {"temperature": {"warning": 65, "critical": 75}},
+  "models": {"MODEL HOT": {"temperature": {"warning": 80, "critical": 85}, "media_errors": {"critical": 1}}}
+}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadHealthConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for model, want := range map[string]healthThresholds{
+		"MODEL A": {
+			Temperature: &threshold{Warning: 65, Critical: 75},
+			PercentUsed: _defaultHealthThresholds.PercentUsed,
+			MediaErrors: _defaultHealthThresholds.MediaErrors,
+			AvailSpare:  _defaultHealthThresholds.AvailSpare,
+		},
+		// nvme list pads the model number with spaces.
+		"MODEL HOT   ": {
+			Temperature: &threshold{Warning: 80, Critical: 85},
+			PercentUsed: _defaultHealthThresholds.PercentUsed,
+			MediaErrors: &threshold{Warning: 0, Critical: 1},
+			AvailSpare:  _defaultHealthThresholds.AvailSpare,
+		},
+	} {
+		if got := config.thresholdsFor(model); !reflect.DeepEqual(got, want) {
+			t.Errorf("thresholdsFor(%q) = %+v, want %+v", model, got, want)
+		}
+	}
+}
+
+func TestHealthEvaluate(t *testing.T) {
+	for name, test := range map[string]struct {
+		smartLog string
+		status   int
+		messages []string
+	}{
+		"healthy": {
+			smartLog: `{"critical_warning":0,"avail_spare":100,"spare_thresh":10,"percent_used":3,"temperature":313}`,
+			status:   exitOK,
+		},
+		"warm and worn": {
+			smartLog: `{"avail_spare":100,"spare_thresh":10,"percent_used":85,"temperature":345}`,
+			status:   exitWarning,
+			messages: []string{"nvme0n1 percent_used 85% > 80%", "nvme0n1 temperature 72 > 70"},
+		},
+		"spare exhausted": {
+			smartLog: `{"critical_warning":1,"avail_spare":5,"spare_thresh":10,"media_errors":3,"temperature":313}`,
+			status:   exitCritical,
+			messages: []string{
+				"nvme0n1 critical_warning 0x1", "nvme0n1 avail_spare 5% < spare_thresh 10%",
+				"nvme0n1 avail_spare 5% < 10%", "nvme0n1 media_errors 3c > 0c",
+			},
+		},
+		"unreadable": {
+			status:   exitUnknown,
+			messages: []string{"nvme0n1 smart-log unavailable"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			device := deviceSnapshot{info: gjson.Parse(`{"DevicePath":"/dev/nvme0n1"}`)}
+			if test.smartLog != "" {
+				device.smartLog = gjson.Parse(test.smartLog)
+			}
+
+			report := &healthReport{}
+			report.evaluate(device, _defaultHealthThresholds)
+
+			if report.status != test.status || !reflect.DeepEqual(report.messages, test.messages) {
+				t.Errorf("evaluate() = %s %q, want %s %q",
+					_statusNames[report.status], report.messages, _statusNames[test.status], test.messages)
+			}
+		})
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	// nvme0 has two namespaces and runs hot, nvme1 cannot be read.
+	collector := testCliCollector(t, map[string]string{
+		"list": `{"Devices":[
+  {"DevicePath":"/dev/nvme0n1","ModelNumber":"MODEL A","SerialNumber":"S0"},
+  {"DevicePath":"/dev/nvme0n2","ModelNumber":"MODEL A","SerialNumber":"S0"},
+  {"DevicePath":"/dev/nvme1n1","ModelNumber":"MODEL A","SerialNumber":"S1"}
+]}`,
+		"smart-log /dev/nvme0n1": `{"avail_spare":100,"spare_thresh":10,"temperature":363}`,
+		"smart-log /dev/nvme0n2": `{"avail_spare":100,"spare_thresh":10,"temperature":363}`,
+	})
+
+	var out bytes.Buffer
+	if status := checkHealth(&out, collector, healthConfig{}); status != exitCritical {
+		t.Errorf("status %s, want CRITICAL", _statusNames[status])
+	}
+
+	want := "NVME CRITICAL - nvme0n1 temperature 90 > 80, nvme1n1 smart-log unavailable | " +
+		"'nvme0n1_avail_spare'=100%;20:;10: 'nvme0n1_percent_used'=0%;80;90 'nvme0n1_media_errors'=0c;0;100 " +
+		"'nvme0n1_temperature'=90;70;80\n"
+	if out.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+const (
+	// helperTimeout bounds a single helper request, including the nvme-cli run.
+	helperTimeout = 30 * time.Second
+	// helperMaxRequestSize is well above any valid request; larger ones are rejected.
+	helperMaxRequestSize = 1024
+	// helperSocketMode lets the socket group connect to the helper.
+	helperSocketMode fs.FileMode = 0o660
+)
+
+// helperRequest is the only message the privileged helper accepts.
+type helperRequest struct {
+	Query  string `json:"query"`
+	Device string `json:"device,omitempty"`
+}
+
+// helperResponse carries the nvme-cli output or the error running it.
+type helperResponse struct {
+	Output []byte `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// helperClient runs queries through the privileged helper listening on socket.
+type helperClient struct {
+	socket string
+}
+
+func (h helperClient) run(query, device string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, "unix", h.socket)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to helper: %w", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(helperTimeout))
+
+	err = json.NewEncoder(conn).Encode(helperRequest{Query: query, Device: device})
+	if err != nil {
+		return nil, fmt.Errorf("error sending helper request: %w", err)
+	}
+
+	response := helperResponse{}
+
+	err = json.NewDecoder(conn).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("error reading helper response: %w", err)
+	}
+
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+
+	return response.Output, nil
+}
+
+// handleHelperConn answers a single request with the output of run. Requests
+// with unknown fields, larger than helperMaxRequestSize or for queries and
+// devices queryArgs does not accept are rejected before anything runs.
+func handleHelperConn(conn net.Conn, run queryRunner) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(helperTimeout))
+
+	request := helperRequest{}
+	decoder := json.NewDecoder(io.LimitReader(conn, helperMaxRequestSize))
+	decoder.DisallowUnknownFields()
+
+	response := helperResponse{}
+
+	err := decoder.Decode(&request)
+	if err == nil {
+		_, err = queryArgs(request.Query, request.Device)
+	}
+
+	if err != nil {
+		response.Error = fmt.Sprintf("invalid helper request: %s", err)
+	} else {
+		response.Output, err = run(request.Query, request.Device)
+		if err != nil {
+			response.Error = err.Error()
+		}
+	}
+
+	if response.Error != "" {
+		log.Printf("Helper request failed: %s\n", response.Error)
+	}
+
+	err = json.NewEncoder(conn).Encode(response)
+	if err != nil {
+		log.Printf("Error writing helper response: %s\n", err)
+	}
+}
+
+// listenHelper creates the helper socket, readable by group when set.
+func listenHelper(socket, group string) (net.Listener, error) {
+	err := os.Remove(socket)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error removing stale helper socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on helper socket: %w", err)
+	}
+
+	err = os.Chmod(socket, helperSocketMode)
+	if err != nil {
+		return nil, fmt.Errorf("error setting helper socket mode: %w", err)
+	}
+
+	if group == "" {
+		return listener, nil
+	}
+
+	socketGroup, err := user.LookupGroup(group)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up helper socket group: %w", err)
+	}
+
+	gid, err := strconv.Atoi(socketGroup.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid for group %s: %w", group, err)
+	}
+
+	err = os.Chown(socket, -1, gid)
+	if err != nil {
+		return nil, fmt.Errorf("error setting helper socket group: %w", err)
+	}
+
+	return listener, nil
+}
+
+func runHelper(args []string) int {
+	flags := flag.NewFlagSet("helper", flag.ExitOnError)
+	socket := flags.String("socket", "/run/nvme_exporter/helper.sock", "Path of the Unix socket to listen on")
+	group := flags.String("socket.group", "", "Group allowed to connect to the socket")
+	flags.Usage = func() {
+		fmt.Println("Usage: nvme_exporter helper [options]")
+		fmt.Println("Runs the privileged helper that performs read-only nvme-cli queries for an unprivileged exporter.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	err := checkPrivileges()
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+
+		return 1
+	}
+
+	version, err := nvmeCliVersion()
+	if err != nil {
+		log.Println(err)
+
+		return 1
+	}
+
+	if !isSupportedVersion(version) {
+		log.Printf("NVMe cli version %s not supported, supported versions are: %v", version, _supportedVersions)
+	}
+
+	listener, err := listenHelper(*socket, *group)
+	if err != nil {
+		log.Println(err)
+
+		return 1
+	}
+	defer listener.Close()
+
+	log.Printf("Starting helper on socket: %s\n", *socket)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Error accepting helper connection: %s\n", err)
+
+			return 1
+		}
+
+		go handleHelperConn(conn, runLocalQuery)
+	}
+}
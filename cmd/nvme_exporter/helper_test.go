@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// testSocketPair returns the two ends of a connected Unix stream socket pair.
+func testSocketPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns := make([]net.Conn, 0, len(fds))
+
+	for _, fd := range fds {
+		file := os.NewFile(uintptr(fd), "helper")
+
+		conn, err := net.FileConn(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = file.Close()
+
+		t.Cleanup(func() { _ = conn.Close() })
+		conns = append(conns, conn)
+	}
+
+	return conns[0], conns[1]
+}
+
+// sendHelperRequest writes a raw request to a helper connection and returns
+// its response and the queries the helper ran.
+func sendHelperRequest(t *testing.T, request string) (helperResponse, []string) {
+	t.Helper()
+
+	var ran []string
+
+	client, server := testSocketPair(t)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		handleHelperConn(server, func(query, device string) ([]byte, error) {
+			ran = append(ran, query+" "+device)
+
+			return []byte(`{"temperature":310}`), nil
+		})
+	}()
+
+	_, err := client.Write([]byte(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := helperResponse{}
+
+	err = json.NewDecoder(client).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+
+	return response, ran
+}
+
+func TestHelperRequest(t *testing.T) {
+	response, ran := sendHelperRequest(t, `{"query":"smart-log","device":"/dev/nvme0n1"}`+"\n")
+	if response.Error != "" || string(response.Output) != `{"temperature":310}` {
+		t.Errorf("response = %+v", response)
+	}
+
+	if len(ran) != 1 || ran[0] != "smart-log /dev/nvme0n1" {
+		t.Errorf("ran %v", ran)
+	}
+}
+
+func TestHelperRequestRejected(t *testing.T) {
+	for name, test := range map[string]struct {
+		request string
+		error   string
+	}{
+		"unknown field": {
+			`{"query":"smart-log","device":"/dev/nvme0n1","args":["format"]}`,
+			`json: unknown field "args"`,
+		},
+		"oversized": {
+			`{"query":"smart-log","device":"/dev/nvme0n` + strings.Repeat("1", helperMaxRequestSize) + `"}`,
+			"unexpected EOF",
+		},
+		"unknown query": {
+			`{"query":"format","device":"/dev/nvme0n1"}`,
+			`unknown query "format"`,
+		},
+		"unknown number query": {
+			`{"query":"endurance-log:1;reboot","device":"/dev/nvme0n1"}`,
+			`unknown query "endurance-log:1;reboot"`,
+		},
+		"invalid device": {
+			`{"query":"smart-log","device":"/dev/sda"}`,
+			`invalid device "/dev/sda"`,
+		},
+		"device traversal": {
+			`{"query":"smart-log","device":"/dev/nvme0n1/../sda"}`,
+			`invalid device "/dev/nvme0n1/../sda"`,
+		},
+		"missing device": {
+			`{"query":"smart-log"}`,
+			`invalid device ""`,
+		},
+		"not json": {
+			"smart-log /dev/nvme0n1\n",
+			"invalid character",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			response, ran := sendHelperRequest(t, test.request)
+			if !strings.HasPrefix(response.Error, "invalid helper request: ") ||
+				!strings.Contains(response.Error, test.error) {
+				t.Errorf("error = %q, want an invalid request error with %q", response.Error, test.error)
+			}
+
+			if len(response.Output) != 0 || len(ran) != 0 {
+				t.Errorf("rejected request ran %v and returned %q", ran, response.Output)
+			}
+		})
+	}
+}
+
+func TestHelperClient(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "helper.sock")
+
+	listener, err := listenHelper(socket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go handleHelperConn(conn, func(string, string) ([]byte, error) {
+				return []byte(`{"Devices":[]}`), nil
+			})
+		}
+	}()
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != helperSocketMode {
+		t.Errorf("socket mode %v, want %v", info.Mode().Perm(), helperSocketMode)
+	}
+
+	client := helperClient{socket: socket}
+
+	output, err := client.run("list", "")
+	if err != nil || string(output) != `{"Devices":[]}` {
+		t.Errorf("run(list) = %q, %v", output, err)
+	}
+
+	_, err = client.run("sanitize", "/dev/nvme0n1")
+	if err == nil || !strings.Contains(err.Error(), `unknown query "sanitize"`) {
+		t.Errorf("run(sanitize) error = %v", err)
+	}
+}
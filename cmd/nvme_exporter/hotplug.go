@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ueventKernelGroup is the netlink multicast group of kernel uevents.
+	ueventKernelGroup = 1
+	ueventBufferSize  = 64 * 1024
+	// sysfsSectorSize is the unit of the sysfs block device size.
+	sysfsSectorSize = 512
+)
+
+// _controllerNameRe matches NVMe controller character devices.
+var _controllerNameRe = regexp.MustCompile(`^nvme\d+$`)
+
+// uevent is the part of a kernel uevent the hotplug watcher cares about.
+type uevent struct {
+	action    string
+	subsystem string
+	devname   string
+}
+
+// parseUevent parses a kernel uevent message, "ACTION@DEVPATH" followed by
+// NUL separated KEY=VALUE pairs. Messages re-broadcast by udev are skipped.
+func parseUevent(msg []byte) (uevent, bool) {
+	fields := bytes.Split(msg, []byte{0})
+	if len(fields) < 2 || !bytes.Contains(fields[0], []byte("@")) {
+		return uevent{}, false
+	}
+
+	event := uevent{}
+
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(string(field), "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "ACTION":
+			event.action = value
+		case "SUBSYSTEM":
+			event.subsystem = value
+		case "DEVNAME":
+			event.devname = strings.TrimPrefix(value, "/dev/")
+		}
+	}
+
+	return event, event.action != ""
+}
+
+// isNvme reports whether the event is about an NVMe controller or namespace.
+// Partitions and the hidden path devices of multipath namespaces are not.
+func (e uevent) isNvme() bool {
+	switch e.subsystem {
+	case "nvme":
+		return _controllerNameRe.MatchString(e.devname)
+	case "block":
+		return _namespaceRe.MatchString(e.devname)
+	default:
+		return false
+	}
+}
+
+// sysfsDevice builds the nvme list entry of a namespace from sysfs. UsedBytes
+// is left out, the kernel does not export the namespace utilization.
+func sysfsDevice(sysRoot, name string) (gjson.Result, bool) {
+	block := filepath.Join(sysRoot, "block", name)
+
+	nameSpace, nsOk := readSysfsFloat(filepath.Join(block, "nsid"))
+	sectors, sizeOk := readSysfsFloat(filepath.Join(block, "size"))
+	sectorSize, sectorOk := readSysfsFloat(filepath.Join(block, "queue", "logical_block_size"))
+	serial := readSysfs(filepath.Join(block, "device", "serial"))
+
+	if !nsOk || !sizeOk || !sectorOk || sectorSize == 0 || serial == "" {
+		return gjson.Result{}, false
+	}
+
+	index, _ := strconv.Atoi(strings.TrimPrefix(name[:strings.LastIndex(name, "n")], "nvme"))
+
+	entry, err := json.Marshal(map[string]interface{}{
+		"NameSpace":    nameSpace,
+		"DevicePath":   "/dev/" + name,
+		"GenericPath":  "/dev/ng" + strings.TrimPrefix(name, "nvme"),
+		"Firmware":     readSysfs(filepath.Join(block, "device", "firmware_rev")),
+		"Index":        index,
+		"ModelNumber":  readSysfs(filepath.Join(block, "device", "model")),
+		"SerialNumber": serial,
+		"MaximumLBA":   sectors * sysfsSectorSize / sectorSize,
+		"PhysicalSize": sectors * sysfsSectorSize,
+		"SectorSize":   sectorSize,
+	})
+	if err != nil {
+		return gjson.Result{}, false
+	}
+
+	return gjson.ParseBytes(entry), true
+}
+
+// hotplugWatcher maintains the device list from udev netlink events, updating
+// namespaces from sysfs as they are added or changed and dropping removed
+// ones. A full nvme list enumeration only runs every rescanInterval, or when
+// events were lost or sysfs could not be read.
+type hotplugWatcher struct {
+	mu             sync.Mutex
+	devices        []gjson.Result
+	stale          bool
+	lastScan       time.Time
+	rescanInterval time.Duration
+	sysRoot        string
+	events         *prometheus.CounterVec
+}
+
+func newHotplugWatcher(rescanInterval time.Duration, sysRoot string) *hotplugWatcher {
+	return &hotplugWatcher{
+		stale:          true,
+		rescanInterval: rescanInterval,
+		sysRoot:        sysRoot,
+		events: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "nvme_device_hotplug_events_total",
+				Help: "Number of udev events seen for NVMe controllers and namespaces",
+			},
+			[]string{"action"},
+		),
+	}
+}
+
+// handle applies a single uevent message to the device set.
+func (h *hotplugWatcher) handle(msg []byte) {
+	event, ok := parseUevent(msg)
+	if !ok || !event.isNvme() {
+		return
+	}
+
+	h.events.WithLabelValues(event.action).Inc()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	devicePath := "/dev/" + event.devname
+	isController := event.subsystem == "nvme"
+
+	switch {
+	case event.action == "remove" && isController:
+		// Removing a controller also removes its namespaces.
+		h.remove(func(path string) bool { return strings.HasPrefix(path, devicePath+"n") })
+	case event.action == "remove":
+		h.remove(func(path string) bool { return path == devicePath })
+	case (event.action == "add" || event.action == "change") && !isController:
+		h.update(event.devname)
+	case event.action == "change":
+		// Firmware activation and other controller changes update the
+		// namespaces of the controller, added namespaces get their own event.
+		for _, device := range h.devices {
+			path := device.Get("DevicePath").String()
+			if strings.HasPrefix(path, devicePath+"n") {
+				h.update(strings.TrimPrefix(path, "/dev/"))
+			}
+		}
+	}
+}
+
+func (h *hotplugWatcher) remove(removed func(devicePath string) bool) {
+	devices := make([]gjson.Result, 0, len(h.devices))
+
+	for _, device := range h.devices {
+		if !removed(device.Get("DevicePath").String()) {
+			devices = append(devices, device)
+		}
+	}
+
+	h.devices = devices
+}
+
+// update inserts or replaces a namespace with its sysfs entry, keeping the
+// UsedBytes of the nvme list entry it replaces.
+func (h *hotplugWatcher) update(name string) {
+	device, ok := sysfsDevice(h.sysRoot, name)
+	if !ok {
+		log.Printf("Cannot read %s from sysfs, enumerating devices again\n", name)
+		h.stale = true
+
+		return
+	}
+
+	for i, known := range h.devices {
+		if known.Get("DevicePath").String() != device.Get("DevicePath").String() {
+			continue
+		}
+
+		if usedBytes := known.Get("UsedBytes"); usedBytes.Exists() {
+			raw := strings.TrimSuffix(device.Raw, "}") + `,"UsedBytes":` + usedBytes.Raw + "}"
+			device = gjson.Parse(raw)
+		}
+
+		h.devices[i] = device
+
+		return
+	}
+
+	h.devices = append(h.devices, device)
+}
+
+// deviceList returns a copy of the maintained device set, enumerating it with
+// list when it is stale. The copy is iterated during collection while events
+// keep updating the set.
+func (h *hotplugWatcher) deviceList(list func() ([]gjson.Result, error)) ([]gjson.Result, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.stale && time.Since(h.lastScan) < h.rescanInterval {
+		return slices.Clone(h.devices), nil
+	}
+
+	devices, err := list()
+	if err != nil {
+		return nil, err
+	}
+
+	h.devices = slices.Clone(devices)
+	h.stale = false
+	h.lastScan = time.Now()
+
+	return devices, nil
+}
+
+// listen subscribes to kernel uevents and handles them in the background.
+func (h *hotplugWatcher) listen() error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return fmt.Errorf("error opening uevent netlink socket: %w", err)
+	}
+
+	err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: ueventKernelGroup})
+	if err != nil {
+		_ = unix.Close(fd)
+
+		return fmt.Errorf("error binding uevent netlink socket: %w", err)
+	}
+
+	go h.receive(fd)
+
+	return nil
+}
+
+func (h *hotplugWatcher) receive(fd int) {
+	buf := make([]byte, ueventBufferSize)
+
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+
+		switch {
+		case errors.Is(err, unix.ENOBUFS):
+			// Events were dropped, the device set can no longer be trusted.
+			h.mu.Lock()
+			h.stale = true
+			h.mu.Unlock()
+		case errors.Is(err, unix.EINTR):
+		case err != nil:
+			log.Printf("Error reading uevents, hotplug events are no longer tracked: %s\n", err)
+
+			h.mu.Lock()
+			h.rescanInterval = 0
+			h.mu.Unlock()
+
+			return
+		default:
+			h.handle(buf[:n])
+		}
+	}
+}
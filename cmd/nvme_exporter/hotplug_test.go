@@ -0,0 +1,260 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// testUevent returns a raw kernel uevent netlink payload.
+func testUevent(action, devpath, subsystem, devname string) []byte {
+	return []byte(action + "@" + devpath + "\x00ACTION=" + action + "\x00DEVPATH=" + devpath +
+		"\x00SUBSYSTEM=" + subsystem + "\x00DEVNAME=" + devname + "\x00SEQNUM=4242\x00")
+}
+
+func testNamespaceUevent(action, name string) []byte {
+	controller := name[:strings.LastIndex(name, "n")]
+
+	return testUevent(action, "/devices/pci0000:00/0000:00:01.0/0000:01:00.0/nvme/"+controller+"/"+name,
+		"block", name)
+}
+
+func testControllerUevent(action, name string) []byte {
+	return testUevent(action, "/devices/pci0000:00/0000:00:01.0/0000:01:00.0/nvme/"+name, "nvme", name)
+}
+
+// writeTestNamespace creates the sysfs attributes of a namespace.
+func writeTestNamespace(t *testing.T, sysRoot, name, serial, firmware string) {
+	t.Helper()
+
+	writeTestFiles(t, filepath.Join(sysRoot, "block", name), map[string]string{
+		"nsid":                     "1",
+		"size":                     "2048",
+		"queue/logical_block_size": "4096",
+		"device/serial":            serial + "  ",
+		"device/model":             "TEST MODEL",
+		"device/firmware_rev":      firmware,
+	})
+}
+
+// testWatcher returns a watcher with a fresh device list of the paths.
+func testWatcher(t *testing.T, paths ...string) *hotplugWatcher {
+	t.Helper()
+
+	h := newHotplugWatcher(time.Hour, t.TempDir())
+
+	devices := make([]gjson.Result, 0, len(paths))
+	for _, path := range paths {
+		devices = append(devices, gjson.Parse(`{"DevicePath":"`+path+`","UsedBytes":1024}`))
+	}
+
+	_, err := h.deviceList(func() ([]gjson.Result, error) { return devices, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func devicePaths(h *hotplugWatcher) []string {
+	paths := make([]string, 0, len(h.devices))
+	for _, device := range h.devices {
+		paths = append(paths, device.Get("DevicePath").String())
+	}
+
+	return paths
+}
+
+func TestParseUevent(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		msg   []byte
+		want  uevent
+		ok    bool
+		isNvm bool
+	}{
+		{"namespace", testNamespaceUevent("add", "nvme0n1"), uevent{"add", "block", "nvme0n1"}, true, true},
+		{"controller", testControllerUevent("remove", "nvme1"), uevent{"remove", "nvme", "nvme1"}, true, true},
+		{"partition", testNamespaceUevent("add", "nvme0n1p1"), uevent{"add", "block", "nvme0n1p1"}, true, false},
+		{"multipath path", testNamespaceUevent("add", "nvme0c0n1"), uevent{"add", "block", "nvme0c0n1"}, true, false},
+		{"sata disk", testUevent("add", "/devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0/block/sda",
+			"block", "sda"), uevent{"add", "block", "sda"}, true, false},
+		{"libudev", []byte("libudev\x00\xfe\xed\xca\xfe\x00ACTION=add\x00SUBSYSTEM=block\x00DEVNAME=nvme0n1\x00"),
+			uevent{}, false, false},
+		{"empty", nil, uevent{}, false, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			event, ok := parseUevent(test.msg)
+			if ok != test.ok || event != test.want {
+				t.Fatalf("parseUevent = %+v, %v, want %+v, %v", event, ok, test.want, test.ok)
+			}
+
+			if event.isNvme() != test.isNvm {
+				t.Errorf("isNvme = %v, want %v", event.isNvme(), test.isNvm)
+			}
+		})
+	}
+}
+
+func TestHotplugAdd(t *testing.T) {
+	h := testWatcher(t, "/dev/nvme0n1")
+	writeTestNamespace(t, h.sysRoot, "nvme1n1", "S2", "1.0")
+
+	h.handle(testControllerUevent("add", "nvme1"))
+	h.handle(testNamespaceUevent("add", "nvme1n1"))
+
+	if h.stale {
+		t.Error("device list marked stale after adding a namespace readable from sysfs")
+	}
+
+	if got := strings.Join(devicePaths(h), " "); got != "/dev/nvme0n1 /dev/nvme1n1" {
+		t.Fatalf("devices = %s", got)
+	}
+
+	added := h.devices[1]
+	for key, want := range map[string]string{
+		"NameSpace":    "1",
+		"GenericPath":  "/dev/ng1n1",
+		"Index":        "1",
+		"SerialNumber": "S2",
+		"ModelNumber":  "TEST MODEL",
+		"Firmware":     "1.0",
+		"PhysicalSize": "1048576",
+		"SectorSize":   "4096",
+		"MaximumLBA":   "256",
+	} {
+		if got := added.Get(key).String(); got != want {
+			t.Errorf("%s = %s, want %s", key, got, want)
+		}
+	}
+
+	if added.Get("UsedBytes").Exists() {
+		t.Error("UsedBytes set for a namespace added from sysfs")
+	}
+
+	if got := testutil.ToFloat64(h.events.WithLabelValues("add")); got != 2 {
+		t.Errorf("add events = %v, want 2", got)
+	}
+}
+
+func TestHotplugChange(t *testing.T) {
+	h := testWatcher(t, "/dev/nvme0n1", "/dev/nvme0n2")
+	writeTestNamespace(t, h.sysRoot, "nvme0n1", "S1", "2.0")
+	writeTestNamespace(t, h.sysRoot, "nvme0n2", "S1", "2.0")
+
+	h.handle(testControllerUevent("change", "nvme0"))
+
+	if h.stale {
+		t.Error("device list marked stale after a controller change readable from sysfs")
+	}
+
+	for _, device := range h.devices {
+		if got := device.Get("Firmware").String(); got != "2.0" {
+			t.Errorf("%s firmware = %s, want 2.0", device.Get("DevicePath"), got)
+		}
+
+		if got := device.Get("UsedBytes").Int(); got != 1024 {
+			t.Errorf("%s used bytes = %d, want 1024", device.Get("DevicePath"), got)
+		}
+	}
+}
+
+func TestHotplugChangeUnreadable(t *testing.T) {
+	h := testWatcher(t, "/dev/nvme0n1")
+
+	h.handle(testNamespaceUevent("change", "nvme0n1"))
+
+	if !h.stale {
+		t.Error("device list not marked stale when sysfs cannot be read")
+	}
+}
+
+func TestHotplugRemove(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		{"controller", testControllerUevent("remove", "nvme1"), "/dev/nvme0n1 /dev/nvme10n1"},
+		{"namespace", testNamespaceUevent("remove", "nvme1n2"), "/dev/nvme0n1 /dev/nvme1n1 /dev/nvme10n1"},
+		{"other controller", testControllerUevent("remove", "nvme10"), "/dev/nvme0n1 /dev/nvme1n1 /dev/nvme1n2"},
+		{"partition", testNamespaceUevent("remove", "nvme1n1p1"),
+			"/dev/nvme0n1 /dev/nvme1n1 /dev/nvme1n2 /dev/nvme10n1"},
+		{"sata disk", testUevent("remove", "/devices/virtual/block/sda", "block", "sda"),
+			"/dev/nvme0n1 /dev/nvme1n1 /dev/nvme1n2 /dev/nvme10n1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := testWatcher(t, "/dev/nvme0n1", "/dev/nvme1n1", "/dev/nvme1n2", "/dev/nvme10n1")
+
+			h.handle(test.msg)
+
+			if got := strings.Join(devicePaths(h), " "); got != test.want {
+				t.Errorf("devices = %s, want %s", got, test.want)
+			}
+
+			if h.stale {
+				t.Error("device list marked stale after a removal")
+			}
+		})
+	}
+}
+
+func TestHotplugIgnoresOtherDevices(t *testing.T) {
+	h := testWatcher(t, "/dev/nvme0n1")
+
+	h.handle(testUevent("add", "/devices/virtual/block/sda", "block", "sda"))
+	h.handle(testNamespaceUevent("add", "nvme0n1p1"))
+
+	if h.stale || len(h.devices) != 1 {
+		t.Errorf("devices = %v, stale %v after non-NVMe events", devicePaths(h), h.stale)
+	}
+
+	if got := testutil.ToFloat64(h.events.WithLabelValues("add")); got != 0 {
+		t.Errorf("add events = %v, want 0", got)
+	}
+}
+
+// TestHotplugUpdateDuringRefresh updates namespaces from events while the
+// collector iterates the device list, run with -race.
+func TestHotplugUpdateDuringRefresh(t *testing.T) {
+	h := testWatcher(t, "/dev/nvme0n1", "/dev/nvme1n1")
+	writeTestNamespace(t, h.sysRoot, "nvme0n1", "S0", "FW1")
+	writeTestNamespace(t, h.sysRoot, "nvme1n1", "S1", "FW1")
+
+	collector := testCliCollector(t, map[string]string{"smart-log": `{"temperature":310}`})
+	collector.hotplug = h
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for range 200 {
+			h.handle(testNamespaceUevent("change", "nvme0n1"))
+			h.handle(testControllerUevent("change", "nvme1"))
+		}
+	}()
+
+	// Refresh until the events are handled, so that both overlap.
+	for refreshing := true; refreshing; {
+		select {
+		case <-done:
+			refreshing = false
+		default:
+		}
+
+		for _, device := range collector.refresh().devices {
+			if device.info.Get("SerialNumber").String() == "" && device.info.Get("UsedBytes").Int() != 1024 {
+				t.Errorf("inconsistent device %s", device.info.Raw)
+			}
+		}
+	}
+
+	if got := devicePaths(h); len(got) != 2 {
+		t.Errorf("devices after the updates = %v", got)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Values of the -label.identity flag, selecting what fills the device label.
+const (
+	identityDevice = "device"
+	identitySerial = "serial"
+	identityWwid   = "wwid"
+	identityPath   = "path"
+)
+
+// _identityLabels are added to every per-device metric when identity labels
+// are enabled. Info metrics already carry serial_number and skip it.
+var _identityLabels = []string{"serial_number", "eui64", "nguid", "uuid", "by_id_path"}
+
+// identityConfig selects how devices are identified in metric labels. Kernel
+// names such as /dev/nvme0n1 depend on enumeration order and can change across
+// reboots, the serial number, WWID and by-id path do not. legacyInfo keeps the
+// nvme_device_info labels on the size gauges, as before that metric existed.
+type identityConfig struct {
+	primary    string
+	labels     bool
+	legacyInfo bool
+	sysRoot    string
+	byIDDir    string
+}
+
+// _defaultIdentity labels devices with their kernel name, as before identity
+// labels existed.
+var _defaultIdentity = identityConfig{primary: identityDevice, sysRoot: "/sys", byIDDir: "/dev/disk/by-id"}
+
+func (i identityConfig) validate() error {
+	switch i.primary {
+	case identityDevice, identitySerial, identityWwid, identityPath:
+		return nil
+	default:
+		return fmt.Errorf("invalid identity label %q, must be one of %s, %s, %s or %s",
+			i.primary, identityDevice, identitySerial, identityWwid, identityPath)
+	}
+}
+
+// deviceIdentity holds the stable identifiers of a namespace.
+type deviceIdentity struct {
+	device string
+	serial string
+	wwid   string
+	eui64  string
+	nguid  string
+	uuid   string
+	byID   string
+	cntlid string
+}
+
+// lookupIdentity reads the namespace identifiers from sysfs and resolves its
+// /dev/disk/by-id link, neither of which needs an admin command.
+func (i identityConfig) lookupIdentity(nvmeDevice gjson.Result) deviceIdentity {
+	return i.blockIdentity(nvmeDevice.Get("DevicePath").String(), nvmeDevice.Get("SerialNumber").String())
+}
+
+func (i identityConfig) blockIdentity(devicePath, serial string) deviceIdentity {
+	block := filepath.Join(i.sysRoot, "block", filepath.Base(devicePath))
+
+	return deviceIdentity{
+		device: devicePath,
+		serial: strings.TrimSpace(serial),
+		wwid:   readSysfs(filepath.Join(block, "wwid")),
+		eui64:  strings.ReplaceAll(readSysfs(filepath.Join(block, "eui")), " ", ""),
+		nguid:  readSysfs(filepath.Join(block, "nguid")),
+		uuid:   readSysfs(filepath.Join(block, "uuid")),
+		byID:   i.byIDPath(devicePath),
+	}
+}
+
+// namespaceIdentity returns the identity and NSID of a namespace block device
+// such as nvme0n1, for collectors reading sysfs instead of nvme list.
+func (i identityConfig) namespaceIdentity(name string) (deviceIdentity, string) {
+	block := filepath.Join(i.sysRoot, "block", name)
+	identity := i.blockIdentity("/dev/"+name, readSysfs(filepath.Join(block, "device", "serial")))
+
+	return identity, readSysfs(filepath.Join(block, "nsid"))
+}
+
+// namespaceLabels returns the per-device label values of a namespace block
+// device such as nvme0n1.
+func (i identityConfig) namespaceLabels(name string) []string {
+	return i.labelValues(i.namespaceIdentity(name))
+}
+
+// controllerIdentity returns the identity of a controller such as nvme0.
+// Controllers have no namespace identifiers, labelled with NSID 1 they get
+// the labels of their first namespace with the serial number identity. The
+// controllers of a multi-port subsystem share its serial number and are told
+// apart by their controller ID.
+func (i identityConfig) controllerIdentity(name string) deviceIdentity {
+	identity := deviceIdentity{
+		device: "/dev/" + name,
+		serial: readSysfs(filepath.Join(i.sysRoot, "class", "nvme", name, "serial")),
+	}
+
+	for _, controller := range controllerDirs(i.sysRoot) {
+		if filepath.Base(controller) == name || identity.serial == "" ||
+			readSysfs(filepath.Join(controller, "serial")) != identity.serial {
+			continue
+		}
+
+		identity.cntlid = readSysfs(filepath.Join(i.sysRoot, "class", "nvme", name, "cntlid"))
+		if identity.cntlid == "" {
+			identity.cntlid = name
+		}
+
+		break
+	}
+
+	return identity
+}
+
+// controllerLabels returns the per-device label values of a controller.
+func (i identityConfig) controllerLabels(name string) []string {
+	return i.labelValues(i.controllerIdentity(name), "1")
+}
+
+// subsystemLabels returns the per-device label values of a subsystem such as
+// nvme-subsys0, for events of a multipath namespace no single controller owns.
+func (i identityConfig) subsystemLabels(name string) []string {
+	return i.labelValues(deviceIdentity{
+		device: name,
+		serial: readSysfs(filepath.Join(i.sysRoot, "class", "nvme-subsystem", name, "serial")),
+	}, "1")
+}
+
+// byIDPath returns the first /dev/disk/by-id link, in lexical order, pointing
+// at the device. udev creates several per namespace and the order keeps the
+// choice stable.
+func (i identityConfig) byIDPath(devicePath string) string {
+	links, _ := filepath.Glob(filepath.Join(i.byIDDir, "nvme-*"))
+
+	for _, link := range links {
+		target, err := filepath.EvalSymlinks(link)
+		if err == nil && target == devicePath {
+			return link
+		}
+	}
+
+	return ""
+}
+
+// primaryLabel returns the device label value. Namespaces share the serial
+// number of their controller, so namespaces other than the first get their
+// NSID appended, and controllers sharing a serial number their controller ID.
+// Missing identifiers fall back to the kernel name.
+func (i identityConfig) primaryLabel(identity deviceIdentity, nameSpace string) string {
+	value := identity.device
+
+	switch i.primary {
+	case identitySerial:
+		if identity.serial != "" {
+			value = identity.serial
+			if nameSpace != "1" {
+				value += "-n" + nameSpace
+			}
+
+			if identity.cntlid != "" {
+				value += "-c" + identity.cntlid
+			}
+		}
+	case identityWwid:
+		if identity.wwid != "" {
+			value = identity.wwid
+		}
+	case identityPath:
+		if identity.byID != "" {
+			value = identity.byID
+		}
+	}
+
+	return value
+}
+
+// labelNames returns the label names of per-device metrics.
+func (i identityConfig) labelNames() []string {
+	if !i.labels {
+		return []string{"device"}
+	}
+
+	return append([]string{"device"}, _identityLabels...)
+}
+
+// infoLabelNames returns the label names of info metrics: the device label,
+// the info labels, serial_number and the other identity labels when enabled.
+func (i identityConfig) infoLabelNames(info ...string) []string {
+	names := append(append([]string{"device"}, info...), "serial_number")
+	if i.labels {
+		names = append(names, _identityLabels[1:]...)
+	}
+
+	return names
+}
+
+// infoLabelValues returns the label values of info metrics, matching
+// infoLabelNames.
+func (i identityConfig) infoLabelValues(identity deviceIdentity, nameSpace string, info ...string) []string {
+	values := append(append([]string{i.primaryLabel(identity, nameSpace)}, info...), identity.serial)
+
+	return append(values, i.extraLabels(identity, false)...)
+}
+
+// labelValues returns the label values of per-device metrics, matching
+// labelNames.
+func (i identityConfig) labelValues(identity deviceIdentity, nameSpace string) []string {
+	return append([]string{i.primaryLabel(identity, nameSpace)}, i.extraLabels(identity, true)...)
+}
+
+// extraLabels returns the identity label values in _identityLabels order,
+// leaving out serial_number for metrics that already carry it.
+func (i identityConfig) extraLabels(identity deviceIdentity, withSerial bool) []string {
+	if !i.labels {
+		return nil
+	}
+
+	values := []string{identity.eui64, identity.nguid, identity.uuid, identity.byID}
+	if withSerial {
+		values = append([]string{identity.serial}, values...)
+	}
+
+	return values
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// kmsgRecordSize is larger than the longest record the kernel returns per read.
+const kmsgRecordSize = 8192
+
+// kmsgEvent is what a recognized kernel message counts as.
+type kmsgEvent int
+
+const (
+	kmsgIoTimeout kmsgEvent = iota
+	// kmsgIoTimeoutReset is an I/O timeout the driver resets the controller
+	// for, counted as both.
+	kmsgIoTimeoutReset
+	kmsgControllerReset
+	kmsgError
+)
+
+// kmsgRule recognizes an nvme driver message. The first submatch of re is the
+// controller or block device name; errType is the type label of kmsgError
+// events.
+type kmsgRule struct {
+	re      *regexp.Regexp
+	event   kmsgEvent
+	errType string
+}
+
+// _kmsgRules are evaluated in order, the first matching rule wins.
+var _kmsgRules = []kmsgRule{
+	{regexp.MustCompile(`^nvme (nvme\d+): I/O .*timeout, reset controller`), kmsgIoTimeoutReset, ""},
+	{regexp.MustCompile(`^nvme (nvme\d+): I/O .*timeout`), kmsgIoTimeout, ""},
+	{regexp.MustCompile(`^nvme (nvme\d+): (resetting controller|controller is down; will reset)`),
+		kmsgControllerReset, ""},
+	{regexp.MustCompile(`^nvme (nvme\d+): failed to set APST feature`), kmsgError, "apst"},
+	{regexp.MustCompile(`^nvme (nvme\d+): (Device not ready; aborting reset|Disabling device after reset failure)`),
+		kmsgError, "reset_failed"},
+	{regexp.MustCompile(`^nvme (nvme\d+): Removing after probe failure`), kmsgError, "probe_failure"},
+	{regexp.MustCompile(`^nvme (nvme\d+): Abort status`), kmsgError, "abort"},
+	// Failed I/O commands, "nvme0n1: Read(0x2) @ LBA 2048, 8 blocks, <status>".
+	{regexp.MustCompile(`^(nvme\d+(?:c\d+)?n\d+): .* @ LBA \d+, \d+ blocks, `), kmsgError, "io_error"},
+	{regexp.MustCompile(`critical medium error, dev (nvme\d+(?:c\d+)?n\d+)`), kmsgError, "medium_error"},
+}
+
+// _kmsgDiskRe matches the namespace block device names in kernel messages.
+// With native multipath the hidden path devices are named
+// nvme<subsystem>c<controller>n<nsid> and the multipath head nvme<subsystem>n<nsid>.
+var _kmsgDiskRe = regexp.MustCompile(`^nvme(\d+)(?:c(\d+))?n\d+$`)
+
+// kmsgMatch is a kernel message recognized by one of the _kmsgRules, name is
+// the controller or block device it is about.
+type kmsgMatch struct {
+	name    string
+	event   kmsgEvent
+	errType string
+}
+
+// parseKmsgRecord extracts the message of a /dev/kmsg record,
+// "priority,sequence,timestamp,flags;message", and matches it against the
+// rules. Continuation lines carrying key/value metadata are ignored.
+func parseKmsgRecord(record string) (kmsgMatch, bool) {
+	_, message, found := strings.Cut(record, ";")
+	if !found {
+		return kmsgMatch{}, false
+	}
+
+	message, _, _ = strings.Cut(message, "\n")
+
+	for _, rule := range _kmsgRules {
+		match := rule.re.FindStringSubmatch(message)
+		if match != nil {
+			return kmsgMatch{name: match[1], event: rule.event, errType: rule.errType}, true
+		}
+	}
+
+	return kmsgMatch{}, false
+}
+
+// kmsgCollector tails the kernel log and counts nvme driver events per
+// controller, labelled like its first namespace, or per subsystem for the
+// errors of a multipath namespace.
+type kmsgCollector struct {
+	identity         identityConfig
+	ioTimeouts       *prometheus.CounterVec
+	controllerResets *prometheus.CounterVec
+	errors           *prometheus.CounterVec
+}
+
+func newKmsgCollector(identity identityConfig) *kmsgCollector {
+	labels := identity.labelNames()
+
+	return &kmsgCollector{
+		identity: identity,
+		ioTimeouts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "nvme_kernel_io_timeouts_total",
+				Help: "Number of I/O timeouts reported by the kernel nvme driver",
+			},
+			labels,
+		),
+		controllerResets: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "nvme_kernel_controller_resets_total",
+				Help: "Number of controller resets reported by the kernel nvme driver",
+			},
+			labels,
+		),
+		errors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "nvme_kernel_errors_total",
+				Help: "Number of errors reported by the kernel nvme driver, by type",
+			},
+			withLabels(labels, "type"),
+		),
+	}
+}
+
+func (c *kmsgCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.ioTimeouts.Describe(ch)
+	c.controllerResets.Describe(ch)
+	c.errors.Describe(ch)
+}
+
+func (c *kmsgCollector) Collect(ch chan<- prometheus.Metric) {
+	c.ioTimeouts.Collect(ch)
+	c.controllerResets.Collect(ch)
+	c.errors.Collect(ch)
+}
+
+// handle counts a single /dev/kmsg record.
+func (c *kmsgCollector) handle(record string) {
+	match, ok := parseKmsgRecord(record)
+	if !ok {
+		return
+	}
+
+	labels := c.labels(match.name)
+
+	switch match.event {
+	case kmsgIoTimeout:
+		c.ioTimeouts.WithLabelValues(labels...).Inc()
+	case kmsgIoTimeoutReset:
+		c.ioTimeouts.WithLabelValues(labels...).Inc()
+		c.controllerResets.WithLabelValues(labels...).Inc()
+	case kmsgControllerReset:
+		c.controllerResets.WithLabelValues(labels...).Inc()
+	case kmsgError:
+		c.errors.WithLabelValues(withLabels(labels, match.errType)...).Inc()
+	}
+}
+
+// labels returns the label values of the controller a message is about. The
+// number in a multipath head name is the subsystem instance, not a controller,
+// so block devices are resolved through their sysfs device link.
+func (c *kmsgCollector) labels(name string) []string {
+	disk := _kmsgDiskRe.FindStringSubmatch(name)
+
+	switch {
+	case disk == nil:
+		return c.identity.controllerLabels(name)
+	case disk[2] != "":
+		return c.identity.controllerLabels("nvme" + disk[2])
+	}
+
+	device, err := filepath.EvalSymlinks(filepath.Join(c.identity.sysRoot, "block", name, "device"))
+	if err == nil && strings.HasPrefix(filepath.Base(device), "nvme-subsys") {
+		return c.identity.subsystemLabels(filepath.Base(device))
+	}
+
+	if err == nil && strings.HasPrefix(filepath.Base(device), "nvme") {
+		return c.identity.controllerLabels(filepath.Base(device))
+	}
+
+	return c.identity.controllerLabels("nvme" + disk[1])
+}
+
+// tail opens the kernel log at path and handles its records in the
+// background, starting with the ones still in the ring buffer.
+func (c *kmsgCollector) tail(path string) error {
+	kmsg, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening kernel log: %w", err)
+	}
+
+	go func() {
+		defer kmsg.Close()
+
+		buf := make([]byte, kmsgRecordSize)
+
+		for {
+			n, err := kmsg.Read(buf)
+
+			switch {
+			case errors.Is(err, syscall.EPIPE):
+				// Records were overwritten before being read, skip ahead.
+			case errors.Is(err, io.EOF):
+				log.Println("Kernel log closed, nvme driver events are no longer tracked")
+
+				return
+			case err != nil:
+				log.Printf("Error reading kernel log, nvme driver events are no longer tracked: %s\n", err)
+
+				return
+			default:
+				c.handle(string(buf[:n]))
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// kmsgCounts are the counter values of a controller after a record.
+type kmsgCounts struct {
+	timeouts float64
+	resets   float64
+	errors   float64
+}
+
+func TestKmsgRecords(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		record     string
+		controller string
+		errType    string
+		want       kmsgCounts
+	}{
+		{
+			"timeout with abort",
+			"4,1502,8211764331,-;nvme nvme0: I/O tag 17 (b011) opcode 0x2 (Read) QID 3 timeout, aborting req_op:READ(0) " +
+				"size:4096\n SUBSYSTEM=nvme\n DEVICE=c242:0\n",
+			"nvme0", "", kmsgCounts{timeouts: 1},
+		},
+		{
+			"timeout with abort, older kernels",
+			"4,880,120338901,-;nvme nvme2: I/O 5 QID 7 timeout, aborting",
+			"nvme2", "", kmsgCounts{timeouts: 1},
+		},
+		{
+			"timeout with reset",
+			"4,1507,8242484402,-;nvme nvme1: I/O tag 17 (b011) opcode 0x2 (Read) QID 3 timeout, reset controller",
+			"nvme1", "", kmsgCounts{timeouts: 1, resets: 1},
+		},
+		{
+			"admin timeout with reset, older kernels",
+			"4,901,130338901,-;nvme nvme10: I/O 0 QID 0 timeout, reset controller",
+			"nvme10", "", kmsgCounts{timeouts: 1, resets: 1},
+		},
+		{
+			"controller down",
+			"4,1511,8242484455,-;nvme nvme0: controller is down; will reset: CSTS=0x3, PCI_STATUS=0x10",
+			"nvme0", "", kmsgCounts{resets: 1},
+		},
+		{
+			"resetting controller",
+			"6,1520,8242490012,-;nvme nvme3: resetting controller",
+			"nvme3", "", kmsgCounts{resets: 1},
+		},
+		{
+			"APST",
+			"3,422,2410391,-;nvme nvme0: failed to set APST feature (2)",
+			"nvme0", "apst", kmsgCounts{errors: 1},
+		},
+		{
+			"probe failure",
+			"4,1530,8302484402,-;nvme nvme1: Removing after probe failure status: -19",
+			"nvme1", "probe_failure", kmsgCounts{errors: 1},
+		},
+		{
+			"reset failure",
+			"4,1528,8302484311,-;nvme nvme1: Disabling device after reset failure: -19",
+			"nvme1", "reset_failed", kmsgCounts{errors: 1},
+		},
+		{
+			"abort status",
+			"4,1503,8211764401,-;nvme nvme0: Abort status: 0x0",
+			"nvme0", "abort", kmsgCounts{errors: 1},
+		},
+		{
+			"I/O error",
+			"3,1540,8312484402,-;nvme0n1: Read(0x2) @ LBA 1953524992, 8 blocks, Unrecovered Read Error (sct 0x2 / sc 0x81) " +
+				"DNR \n SUBSYSTEM=block\n",
+			"nvme0", "io_error", kmsgCounts{errors: 1},
+		},
+		{
+			"medium error",
+			"3,1541,8312484455,-;critical medium error, dev nvme0n1, sector 1953524992 op 0x0:(READ) flags 0x0 " +
+				"phys_seg 1 prio class 2",
+			"nvme0", "medium_error", kmsgCounts{errors: 1},
+		},
+		{
+			"multipath medium error",
+			"3,1542,8312484499,-;critical medium error, dev nvme2c5n1, sector 8 op 0x0:(READ) flags 0x0",
+			"nvme5", "medium_error", kmsgCounts{errors: 1},
+		},
+		{
+			"other driver",
+			"6,1600,8400000000,-;ata1: SATA link up 6.0 Gbps (SStatus 133 SControl 300)",
+			"", "", kmsgCounts{},
+		},
+		{
+			"continuation only",
+			" SUBSYSTEM=nvme\n",
+			"", "", kmsgCounts{},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			match, ok := parseKmsgRecord(test.record)
+			if ok != (test.controller != "") {
+				t.Fatalf("parseKmsgRecord matched %v", ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			c := newKmsgCollector(testIdentity(t.TempDir()))
+			if device := c.labels(match.name)[0]; device != "/dev/"+test.controller || match.errType != test.errType {
+				t.Errorf("device %s, type %q, want /dev/%s, %q", device, match.errType, test.controller, test.errType)
+			}
+
+			c.handle(test.record)
+
+			device := "/dev/" + test.controller
+			got := kmsgCounts{
+				timeouts: testutil.ToFloat64(c.ioTimeouts.WithLabelValues(device)),
+				resets:   testutil.ToFloat64(c.controllerResets.WithLabelValues(device)),
+				errors:   testutil.ToFloat64(c.errors.WithLabelValues(device, test.errType)),
+			}
+			if got != test.want {
+				t.Errorf("counts %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestKmsgIdentityLabels(t *testing.T) {
+	identity := testIdentity(t.TempDir())
+	identity.primary = identitySerial
+	identity.labels = true
+	writeTestFiles(t, identity.sysRoot, map[string]string{"class/nvme/nvme0/serial": "S64FNE0R800001  "})
+
+	c := newKmsgCollector(identity)
+	c.handle("4,1507,8242484402,-;nvme nvme0: I/O tag 17 (b011) opcode 0x2 (Read) QID 3 timeout, reset controller")
+	c.handle("3,422,2410391,-;nvme nvme1: failed to set APST feature (2)")
+
+	expected := `
+# HELP nvme_kernel_controller_resets_total Number of controller resets reported by the kernel nvme driver
+# TYPE nvme_kernel_controller_resets_total counter
+nvme_kernel_controller_resets_total{by_id_path="",device="S64FNE0R800001",eui64="",nguid="",serial_number="S64FNE0R800001",uuid=""} 1
+# HELP nvme_kernel_errors_total Number of errors reported by the kernel nvme driver, by type
+# TYPE nvme_kernel_errors_total counter
+nvme_kernel_errors_total{by_id_path="",device="/dev/nvme1",eui64="",nguid="",serial_number="",type="apst",uuid=""} 1
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nvme_kernel_controller_resets_total", "nvme_kernel_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKmsgMultipathLabels(t *testing.T) {
+	identity := testIdentity(t.TempDir())
+	identity.primary = identitySerial
+	writeTestFiles(t, identity.sysRoot, map[string]string{
+		"class/nvme-subsystem/nvme-subsys0/serial": "S1",
+		"class/nvme/nvme3/serial":                  "S2",
+		"class/nvme/nvme4/serial":                  "S3",
+	})
+
+	// nvme0n1 is a multipath head, nvme1n1 a namespace of controller nvme3
+	// whose subsystem is instance 1.
+	for link, target := range map[string]string{
+		"block/nvme0n1/device": "class/nvme-subsystem/nvme-subsys0",
+		"block/nvme1n1/device": "class/nvme/nvme3",
+	} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(identity.sysRoot, link)), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.Symlink(filepath.Join(identity.sysRoot, target), filepath.Join(identity.sysRoot, link))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newKmsgCollector(identity)
+
+	for name, want := range map[string]string{
+		"nvme0n1":   "S1",
+		"nvme1n1":   "S2",
+		"nvme0c4n1": "S3",
+		"nvme7n1":   "/dev/nvme7",
+		"nvme3":     "S2",
+	} {
+		if got := c.labels(name)[0]; got != want {
+			t.Errorf("device label of %s = %q, want %q", name, got, want)
+		}
+	}
+
+	c.handle("3,1541,8312484455,-;critical medium error, dev nvme0n1, sector 8 op 0x0:(READ) flags 0x0")
+
+	if got := testutil.ToFloat64(c.errors.WithLabelValues("S1", "medium_error")); got != 1 {
+		t.Errorf("medium errors of the subsystem = %v, want 1", got)
+	}
+}
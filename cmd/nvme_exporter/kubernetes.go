@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	kubernetesTimeout = 10 * time.Second
+	hostnameLabel     = "kubernetes.io/hostname"
+	// nodeNameField is the only field a node selector term can match.
+	nodeNameField = "metadata.name"
+)
+
+// _nodeSelectorOperators maps the node selector operators to label selector
+// operators.
+var _nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// _namespacePrefixRe extracts the namespace from a device or partition name.
+var _namespacePrefixRe = regexp.MustCompile(`^nvme\d+n\d+`)
+
+// newInClusterClient returns a client authenticated with the pod service
+// account, whose token client-go reloads as it is rotated.
+func newInClusterClient() (kubernetes.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading in-cluster Kubernetes configuration: %w", err)
+	}
+
+	config.Timeout = kubernetesTimeout
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+
+	return client, nil
+}
+
+// kubernetesVolume is a local PersistentVolume of this node and what uses it.
+type kubernetesVolume struct {
+	device string
+	pv     string
+	pvc    string
+	ns     string
+	pod    string
+}
+
+// kubernetesCollector maps local PersistentVolumes of this node, and the pods
+// using their claims, to the NVMe namespaces backing them. The API server is
+// queried at most once per refresh interval.
+type kubernetesCollector struct {
+	mu              sync.Mutex
+	client          kubernetes.Interface
+	node            string
+	usage           *usageCollector
+	devRoot         string
+	refreshInterval time.Duration
+	lastRefresh     time.Time
+	volumes         []kubernetesVolume
+	nvmeK8sVolume   *prometheus.Desc
+}
+
+func newKubernetesCollector(
+	client kubernetes.Interface, node string, usage *usageCollector, refreshInterval time.Duration,
+) *kubernetesCollector {
+	return &kubernetesCollector{
+		client:          client,
+		node:            node,
+		usage:           usage,
+		devRoot:         "/dev",
+		refreshInterval: refreshInterval,
+		nvmeK8sVolume: prometheus.NewDesc(
+			"nvme_kubernetes_volume_info",
+			"Kubernetes local PersistentVolume, claim and pod backed by the namespace",
+			usage.identity.infoLabelNames("pv", "pvc", "namespace", "pod"),
+			nil,
+		),
+	}
+}
+
+func (c *kubernetesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeK8sVolume
+}
+
+func (c *kubernetesCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastRefresh) >= c.refreshInterval {
+		volumes, err := c.refresh()
+		if err != nil {
+			log.Printf("Error mapping Kubernetes volumes: %s\n", err)
+		} else {
+			c.volumes = volumes
+			c.lastRefresh = time.Now()
+		}
+	}
+
+	for _, volume := range c.volumes {
+		identity, nameSpace := c.usage.identity.namespaceIdentity(filepath.Base(volume.device))
+
+		ch <- prometheus.MustNewConstMetric(c.nvmeK8sVolume, prometheus.GaugeValue, 1, c.usage.identity.infoLabelValues(
+			identity, nameSpace, volume.pv, volume.pvc, volume.ns, volume.pod)...)
+	}
+}
+
+func (c *kubernetesCollector) refresh() ([]kubernetesVolume, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesTimeout)
+	defer cancel()
+
+	node, err := c.client.CoreV1().Nodes().Get(ctx, c.node, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting node %s: %w", c.node, err)
+	}
+
+	pvs, err := c.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing PersistentVolumes: %w", err)
+	}
+
+	pods, err := c.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", c.node).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods of node %s: %w", c.node, err)
+	}
+
+	claimPods := podsByClaim(pods.Items, c.node)
+	usages := c.usage.namespaceUsages()
+
+	var volumes []kubernetesVolume
+
+	for _, pv := range pvs.Items {
+		if pv.Spec.Local == nil || !onNode(&pv, node) {
+			continue
+		}
+
+		volume := kubernetesVolume{pv: pv.Name}
+		if pv.Spec.ClaimRef != nil {
+			volume.pvc = pv.Spec.ClaimRef.Name
+			volume.ns = pv.Spec.ClaimRef.Namespace
+		}
+
+		users := claimPods[volume.ns+"/"+volume.pvc]
+
+		for _, used := range c.resolve(pv.Spec.Local.Path, usages) {
+			volume.device = used.device
+			volume.pod = ""
+
+			if len(users) == 0 {
+				volumes = append(volumes, volume)
+			}
+
+			for _, pod := range users {
+				volume.pod = pod
+				volumes = append(volumes, volume)
+			}
+		}
+	}
+
+	return volumes, nil
+}
+
+// onNode reports whether the PersistentVolume node affinity selects node:
+// one of its terms matches all its expressions against the node labels and
+// all its fields against the node name.
+func onNode(pv *corev1.PersistentVolume, node *corev1.Node) bool {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return false
+	}
+
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+
+		if matchesNode(term.MatchExpressions, labels.Set(node.Labels)) &&
+			matchesNode(term.MatchFields, labels.Set{nodeNameField: node.Name}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesNode reports whether the node selector requirements all match the
+// node labels or fields, an invalid requirement matches nothing.
+func matchesNode(requirements []corev1.NodeSelectorRequirement, set labels.Set) bool {
+	for _, requirement := range requirements {
+		operator, ok := _nodeSelectorOperators[requirement.Operator]
+		if !ok {
+			return false
+		}
+
+		selector, err := labels.NewRequirement(requirement.Key, operator, requirement.Values)
+		if err != nil || !selector.Matches(set) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// podsByClaim maps "namespace/claim" to the names of the pods of node
+// mounting it.
+func podsByClaim(pods []corev1.Pod, node string) map[string][]string {
+	claims := map[string][]string{}
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName != node {
+			continue
+		}
+
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+
+			key := pod.Namespace + "/" + volume.PersistentVolumeClaim.ClaimName
+			claims[key] = append(claims[key], pod.Name)
+		}
+	}
+
+	return claims
+}
+
+// resolve finds the namespaces backing a local volume path, either a block
+// device (volumeMode Block) or a directory on a mounted filesystem. A
+// filesystem on an md array or LVM volume is backed by all of its members.
+func (c *kubernetesCollector) resolve(path string, usages []namespaceUsage) []namespaceUsage {
+	if strings.HasPrefix(path, c.devRoot+"/") {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			path = resolved
+		}
+
+		name := _namespacePrefixRe.FindString(filepath.Base(path))
+		for _, used := range usages {
+			if name != "" && used.device == "/dev/"+name {
+				return []namespaceUsage{used}
+			}
+		}
+
+		return nil
+	}
+
+	mountpoint := ""
+
+	for _, used := range usages {
+		if used.mountpoint == "" || len(used.mountpoint) <= len(mountpoint) {
+			continue
+		}
+
+		if path == used.mountpoint || strings.HasPrefix(path, strings.TrimSuffix(used.mountpoint, "/")+"/") {
+			mountpoint = used.mountpoint
+		}
+	}
+
+	var backing []namespaceUsage
+
+	for _, used := range usages {
+		if mountpoint != "" && used.mountpoint == mountpoint {
+			backing = append(backing, used)
+		}
+	}
+
+	return backing
+}
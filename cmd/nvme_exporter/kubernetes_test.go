@@ -0,0 +1,185 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNode = "node-a"
+
+// testNodeObject returns the node testNode with hostname, zone and disk count
+// labels.
+func testNodeObject() *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   testNode,
+		Labels: map[string]string{hostnameLabel: testNode, "topology.kubernetes.io/zone": "zone-1", "disks": "4"},
+	}}
+}
+
+// testNodeAffinity returns a node affinity selecting the nodes with key.
+func testNodeAffinity(key string, operator corev1.NodeSelectorOperator, nodes ...string) *corev1.VolumeNodeAffinity {
+	return &corev1.VolumeNodeAffinity{
+		Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: key, Operator: operator, Values: nodes}},
+			}},
+		},
+	}
+}
+
+// testLocalPV returns a local PersistentVolume of node bound to a claim.
+func testLocalPV(name, path, node, namespace, claim string) *corev1.PersistentVolume {
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{Local: &corev1.LocalVolumeSource{Path: path}},
+			NodeAffinity:           testNodeAffinity(hostnameLabel, corev1.NodeSelectorOpIn, node),
+		},
+	}
+
+	if claim != "" {
+		pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: namespace, Name: claim}
+	}
+
+	return pv
+}
+
+// testPod returns a pod of node mounting the claims.
+func testPod(name, namespace, node string, claims ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         "config",
+		VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}},
+	})
+
+	for _, claim := range claims {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: claim,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			},
+		})
+	}
+
+	return pod
+}
+
+func TestKubernetesVolumes(t *testing.T) {
+	usage := testUsageTree(t)
+
+	devRoot := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(devRoot, "disk", "by-id"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, devRoot, map[string]string{"nvme3n1": ""})
+
+	err = os.Symlink(filepath.Join(devRoot, "nvme3n1"), filepath.Join(devRoot, "disk", "by-id", "nvme-TEST_S3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nfs := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-nfs"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{NFS: &corev1.NFSVolumeSource{Path: "/srv/my data"}},
+		},
+	}
+
+	objects := []runtime.Object{
+		testLocalPV("pv-lvm", "/srv/my data/pv-lvm", testNode, "db", "data"),
+		testLocalPV("pv-root", "/var/lib/local/pv-root", testNode, "web", "logs"),
+		testLocalPV("pv-block", filepath.Join(devRoot, "disk", "by-id", "nvme-TEST_S3"), testNode, "", ""),
+		testLocalPV("pv-partition", filepath.Join(devRoot, "nvme2n1p3"), testNode, "batch", "scratch"),
+		testLocalPV("pv-sata", filepath.Join(devRoot, "sda"), testNode, "", ""),
+		testLocalPV("pv-other-node", "/srv/my data/pv-other-node", "node-b", "db", "data"),
+		nfs,
+		testPod("db-0", "db", testNode, "data"),
+		testPod("db-1", "db", testNode, "data", "other"),
+		testPod("db-2", "db", "node-b", "data"),
+		testPod("web-0", "other", testNode, "logs"),
+		testNodeObject(),
+	}
+
+	c := newKubernetesCollector(fake.NewClientset(objects...), testNode, usage, time.Minute)
+	c.devRoot = devRoot
+
+	volumes, err := c.refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []kubernetesVolume{
+		{device: "/dev/nvme3n1", pv: "pv-block"},
+		{device: "/dev/nvme0n1", pv: "pv-lvm", pvc: "data", ns: "db", pod: "db-0"},
+		{device: "/dev/nvme0n1", pv: "pv-lvm", pvc: "data", ns: "db", pod: "db-1"},
+		{device: "/dev/nvme1n1", pv: "pv-lvm", pvc: "data", ns: "db", pod: "db-0"},
+		{device: "/dev/nvme1n1", pv: "pv-lvm", pvc: "data", ns: "db", pod: "db-1"},
+		{device: "/dev/nvme2n1", pv: "pv-partition", pvc: "scratch", ns: "batch"},
+		{device: "/dev/nvme2n1", pv: "pv-root", pvc: "logs", ns: "web"},
+	}
+
+	if !reflect.DeepEqual(volumes, want) {
+		t.Errorf("volumes = %+v, want %+v", volumes, want)
+	}
+}
+
+func TestOnNode(t *testing.T) {
+	zone := testNodeAffinity("topology.kubernetes.io/zone", corev1.NodeSelectorOpIn, "zone-1")
+	zone.Required.NodeSelectorTerms[0].MatchExpressions = append(zone.Required.NodeSelectorTerms[0].MatchExpressions,
+		corev1.NodeSelectorRequirement{Key: "disks", Operator: corev1.NodeSelectorOpGt, Values: []string{"8"}})
+
+	terms := testNodeAffinity(hostnameLabel, corev1.NodeSelectorOpIn, "node-b")
+	terms.Required.NodeSelectorTerms = append(terms.Required.NodeSelectorTerms, corev1.NodeSelectorTerm{
+		MatchFields: []corev1.NodeSelectorRequirement{
+			{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{testNode}},
+		},
+	})
+
+	for _, test := range []struct {
+		name     string
+		affinity *corev1.VolumeNodeAffinity
+		want     bool
+	}{
+		{"hostname", testNodeAffinity(hostnameLabel, corev1.NodeSelectorOpIn, "node-b", testNode), true},
+		{"other node", testNodeAffinity(hostnameLabel, corev1.NodeSelectorOpIn, "node-b"), false},
+		{"not in", testNodeAffinity(hostnameLabel, corev1.NodeSelectorOpNotIn, "node-b"), true},
+		{"not in this node", testNodeAffinity(hostnameLabel, corev1.NodeSelectorOpNotIn, testNode), false},
+		{"zone", testNodeAffinity("topology.kubernetes.io/zone", corev1.NodeSelectorOpIn, "zone-1"), true},
+		{"other zone", testNodeAffinity("topology.kubernetes.io/zone", corev1.NodeSelectorOpIn, "zone-2"), false},
+		{"exists", testNodeAffinity("disks", corev1.NodeSelectorOpExists), true},
+		{"does not exist", testNodeAffinity("disks", corev1.NodeSelectorOpDoesNotExist), false},
+		{"greater than", testNodeAffinity("disks", corev1.NodeSelectorOpGt, "2"), true},
+		{"all expressions of a term", zone, false},
+		{"any term", terms, true},
+		{"hostname as a field", testNodeAffinity("metadata.name", corev1.NodeSelectorOpIn, testNode), false},
+		{"invalid operator", testNodeAffinity(hostnameLabel, "Equals", testNode), false},
+		{"empty term", &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{}},
+		}}, false},
+		{"no required terms", &corev1.VolumeNodeAffinity{}, false},
+		{"no affinity", nil, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pv := &corev1.PersistentVolume{Spec: corev1.PersistentVolumeSpec{NodeAffinity: test.affinity}}
+
+			if got := onNode(pv, testNodeObject()); got != test.want {
+				t.Errorf("onNode = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
-	"os/user"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,8 +28,85 @@ func isSupportedVersion(version string) bool {
 	return ok
 }
 
+// _smartLogFields are the nvme smart-log JSON fields exported as metrics, in
+// the order expected by sendSmartLogMetrics.
+var _smartLogFields = []string{
+	"critical_warning",
+	"temperature",
+	"avail_spare",
+	"spare_thresh",
+	"percent_used",
+	"endurance_grp_critical_warning_summary",
+	"data_units_read",
+	"data_units_written",
+	"host_read_commands",
+	"host_write_commands",
+	"controller_busy_time",
+	"power_cycles",
+	"power_on_hours",
+	"unsafe_shutdowns",
+	"media_errors",
+	"num_err_log_entries",
+	"warning_temp_time",
+	"critical_comp_time",
+	"thm_temp1_trans_count",
+	"thm_temp2_trans_count",
+	"thm_temp1_total_time",
+	"thm_temp2_total_time",
+}
+
+// _ocpSmartLogFields are the nvme ocp smart-add-log JSON fields exported as
+// metrics, in the order expected by sendOcpSmartLogMetrics.
+var _ocpSmartLogFields = []string{
+	"Physical media units written.hi",
+	"Physical media units written.lo",
+	"Physical media units read.hi",
+	"Physical media units read.lo",
+	"Bad user nand blocks - Raw",
+	"Bad user nand blocks - Normalized",
+	"Bad system nand blocks - Raw",
+	"Bad system nand blocks - Normalized",
+	"XOR recovery count",
+	"Uncorrectable read error count",
+	"Soft ecc error count",
+	"End to end detected errors",
+	"End to end corrected errors",
+	"System data percent used",
+	"Refresh counts",
+	"Max User data erase counts",
+	"Min User data erase counts",
+	"Number of Thermal throttling events",
+	"Current throttling status",
+	"PCIe correctable error count",
+	"Incomplete shutdowns",
+	"Percent free blocks",
+	"Capacitor health",
+	"Unaligned I/O",
+	"Security Version Number",
+	"NUSE - Namespace utilization",
+	"PLP start count",
+	"Endurance estimate",
+	"Log page version",
+	"Log page GUID",
+	"Errata Version Field",
+	"Point Version Field",
+	"Minor Version Field",
+	"Major Version Field",
+	"NVMe Errata Version",
+	"PCIe Link Retraining Count",
+	"Power State Change Count",
+}
+
 type nvmeCollector struct {
+	mu                                     sync.Mutex
+	last                                   *snapshot
+	device                                 string
+	run                                    queryRunner
+	hotplug                                *hotplugWatcher
+	identity                               identityConfig
+	logPages                               []logPageCollector
 	ocp                                    bool
+	sanitize                               bool
 	nvmeCriticalWarning                    *prometheus.Desc
 	nvmeTemperature                        *prometheus.Desc
 	nvmeAvailSpare                         *prometheus.Desc
@@ -92,14 +171,26 @@ type nvmeCollector struct {
 	nvmeMaximumLba                         *prometheus.Desc
 	nvmePhysicalSize                       *prometheus.Desc
 	nvmeSectorSize                         *prometheus.Desc
+	nvmeDeviceInfo                         *prometheus.Desc
 }
 
-func newNvmeCollector(ocp bool) prometheus.Collector {
-	labels := []string{"device"}
+func newNvmeCollector(ocp bool, identity identityConfig) *nvmeCollector {
+	labels := identity.labelNames()
 	infoLabels := []string{"device", "generic_path", "firmware", "model_number", "serial_number"}
 
+	if identity.labels {
+		infoLabels = append(infoLabels, _identityLabels[1:]...)
+	}
+
+	sizeLabels := labels
+	if identity.legacyInfo {
+		sizeLabels = infoLabels
+	}
+
 	return &nvmeCollector{
-		ocp: ocp,
+		run:      runLocalQuery,
+		ocp:      ocp,
+		identity: identity,
 		nvmeCriticalWarning: prometheus.NewDesc(
 			"nvme_critical_warning",
 			"Critical warnings for the state of the controller",
@@ -457,30 +548,36 @@ func newNvmeCollector(ocp bool) prometheus.Collector {
 		nvmeNameSpace: prometheus.NewDesc(
 			"nvme_namespace",
 			"",
-			infoLabels,
+			sizeLabels,
 			nil,
 		),
 		nvmeUsedBytes: prometheus.NewDesc(
 			"nvme_used_bytes",
 			"",
-			infoLabels,
+			sizeLabels,
 			nil,
 		),
 		nvmeMaximumLba: prometheus.NewDesc(
 			"nvme_maximum_lba",
 			"",
-			infoLabels,
+			sizeLabels,
 			nil,
 		),
 		nvmePhysicalSize: prometheus.NewDesc(
 			"nvme_physical_size",
 			"",
-			infoLabels,
+			sizeLabels,
 			nil,
 		),
 		nvmeSectorSize: prometheus.NewDesc(
 			"nvme_sector_size",
 			"",
+			sizeLabels,
+			nil,
+		),
+		nvmeDeviceInfo: prometheus.NewDesc(
+			"nvme_device_info",
+			"Device identity from nvme list, always 1",
 			infoLabels,
 			nil,
 		),
@@ -552,9 +649,19 @@ func (c *nvmeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.nvmeMaximumLba
 	ch <- c.nvmePhysicalSize
 	ch <- c.nvmeSectorSize
+	ch <- c.nvmeDeviceInfo
+
+	for _, page := range c.logPages {
+		page.describe(ch)
+	}
 }
 
 func executeCommand(cmd string, args ...string) ([]byte, error) {
+	err := checkCommandAllowed(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+
 	command := exec.Command(cmd, args...)
 
 	output, err := command.CombinedOutput()
@@ -569,256 +676,301 @@ func executeCommand(cmd string, args ...string) ([]byte, error) {
 	return output, nil
 }
 
+// executeBinaryCommand runs an allowed command whose output is binary, such as
+// a raw log page. Stderr is kept apart so it cannot corrupt the output.
+func executeBinaryCommand(cmd string, args ...string) ([]byte, error) {
+	err := checkCommandAllowed(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+
+	command := exec.Command(cmd, args...)
+	command.Stderr = &stderr
+
+	output, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running %s command: %w, output: %s", cmd, err, stderr.String())
+	}
+
+	return output, nil
+}
+
 func (c *nvmeCollector) Collect(ch chan<- prometheus.Metric) {
-	nvmeDeviceList := c.getDeviceList()
-	for _, nvmeDevice := range nvmeDeviceList {
-		c.sendInfoMetrics(ch, nvmeDevice)
-		nvmeDevicePath := nvmeDevice.Get("DevicePath")
-		c.collectSmartLogMetrics(ch, nvmeDevicePath)
+	snap := c.refresh()
+	for _, device := range snap.devices {
+		c.sendInfoMetrics(ch, device.info, device.identity)
+		labels := c.identity.labelValues(device.identity, device.info.Get("NameSpace").String())
+		c.sendSmartLogMetrics(ch, gjson.GetMany(device.smartLog.Raw, _smartLogFields...), labels)
 
 		if c.ocp {
-			c.collectOcpSmartLogMetrics(ch, nvmeDevicePath)
+			c.sendOcpSmartLogMetrics(ch, gjson.GetMany(device.ocpSmartLog.Raw, _ocpSmartLogFields...), labels)
+		}
+
+		for _, page := range c.logPages {
+			page.send(ch, device, labels)
 		}
 	}
 }
 
-func (c *nvmeCollector) getDeviceList() []gjson.Result {
-	nvmeDeviceCmd, err := executeCommand("nvme", "list", "-o", "json")
+func (c *nvmeCollector) getDeviceList() ([]gjson.Result, error) {
+	nvmeDeviceCmd, err := c.run("list", "")
 	if err != nil {
-		log.Printf("Error running nvme list -o json: %s\n", err)
+		return nil, fmt.Errorf("error running nvme list -o json: %w", err)
+	}
+
+	return gjson.GetBytes(nvmeDeviceCmd, "Devices").Array(), nil
+}
+
+// listDevices returns the devices to collect, from the hotplug watcher when
+// enabled or by enumerating them with nvme list.
+func (c *nvmeCollector) listDevices() ([]gjson.Result, error) {
+	if c.hotplug == nil {
+		return c.getDeviceList()
 	}
 
-	// return gjson.Get(string(nvmeDeviceCmd), "Devices.#.DevicePath").Array()
-	return gjson.Get(string(nvmeDeviceCmd), "Devices").Array()
+	return c.hotplug.deviceList(c.getDeviceList)
 }
 
-func (c *nvmeCollector) collectSmartLogMetrics(ch chan<- prometheus.Metric, device gjson.Result) {
-	nvmeSmartLog, err := executeCommand("nvme", "smart-log", device.String(), "-o", "json")
+func (c *nvmeCollector) getSmartLog(device string) (gjson.Result, error) {
+	nvmeSmartLog, err := c.run("smart-log", device)
 	if err != nil {
-		log.Printf("Error running smart-log %s -o json: %s\n", device.String(), err)
-	}
-
-	nvmeSmartLogMetrics := gjson.GetMany(string(nvmeSmartLog),
-		"critical_warning",
-		"temperature",
-		"avail_spare",
-		"spare_thresh",
-		"percent_used",
-		"endurance_grp_critical_warning_summary",
-		"data_units_read",
-		"data_units_written",
-		"host_read_commands",
-		"host_write_commands",
-		"controller_busy_time",
-		"power_cycles",
-		"power_on_hours",
-		"unsafe_shutdowns",
-		"media_errors",
-		"num_err_log_entries",
-		"warning_temp_time",
-		"critical_comp_time",
-		"thm_temp1_trans_count",
-		"thm_temp2_trans_count",
-		"thm_temp1_total_time",
-		"thm_temp2_total_time")
-	c.sendSmartLogMetrics(ch, nvmeSmartLogMetrics, device.String())
+		return gjson.Result{}, fmt.Errorf("error running smart-log %s -o json: %w", device, err)
+	}
+
+	return gjson.ParseBytes(nvmeSmartLog), nil
 }
 
-func (c *nvmeCollector) collectOcpSmartLogMetrics(ch chan<- prometheus.Metric, device gjson.Result) {
-	nvmeOcpSmartLog, err := executeCommand("nvme", "ocp", "smart-add-log", device.String(), "-o", "json")
+func (c *nvmeCollector) getOcpSmartLog(device string) (gjson.Result, error) {
+	nvmeOcpSmartLog, err := c.run("ocp-smart-add-log", device)
 	if err != nil {
-		log.Printf("Error running smart-add-log %s -o json: %s\n", device.String(), err)
-	}
-
-	nvmeOcpSmartLogMetrics := gjson.GetMany(string(nvmeOcpSmartLog),
-		"Physical media units written.hi",
-		"Physical media units written.lo",
-		"Physical media units read.hi",
-		"Physical media units read.lo",
-		"Bad user nand blocks - Raw",
-		"Bad user nand blocks - Normalized",
-		"Bad system nand blocks - Raw",
-		"Bad system nand blocks - Normalized",
-		"XOR recovery count",
-		"Uncorrectable read error count",
-		"Soft ecc error count",
-		"End to end detected errors",
-		"End to end corrected errors",
-		"System data percent used",
-		"Refresh counts",
-		"Max User data erase counts",
-		"Min User data erase counts",
-		"Number of Thermal throttling events",
-		"Current throttling status",
-		"PCIe correctable error count",
-		"Incomplete shutdowns",
-		"Percent free blocks",
-		"Capacitor health",
-		"Unaligned I/O",
-		"Security Version Number",
-		"NUSE - Namespace utilization",
-		"PLP start count",
-		"Endurance estimate",
-		"Log page version",
-		"Log page GUID",
-		"Errata Version Field",
-		"Point Version Field",
-		"Minor Version Field",
-		"Major Version Field",
-		"NVMe Errata Version",
-		"PCIe Link Retraining Count",
-		"Power State Change Count")
-	c.sendOcpSmartLogMetrics(ch, nvmeOcpSmartLogMetrics, device.String())
+		return gjson.Result{}, fmt.Errorf("error running smart-add-log %s -o json: %w", device, err)
+	}
+
+	return gjson.ParseBytes(nvmeOcpSmartLog), nil
 }
 
-func (c *nvmeCollector) sendInfoMetrics(ch chan<- prometheus.Metric, device gjson.Result) {
+func (c *nvmeCollector) sendInfoMetrics(ch chan<- prometheus.Metric, device gjson.Result, identity deviceIdentity) {
 	nameSpace := device.Get("NameSpace").Float()
-	devicePath := device.Get("DevicePath").String()
 	genericPath := device.Get("GenericPath").String()
 	firmware := device.Get("Firmware").String()
 	modelNumber := device.Get("ModelNumber").String()
 	serialNumber := device.Get("SerialNumber").String()
-	usedBytes := device.Get("UsedBytes").Float()
+	usedBytes := device.Get("UsedBytes")
 	maximumLba := device.Get("MaximumLBA").Float()
 	physicalSize := device.Get("PhysicalSize").Float()
 	sectorSize := device.Get("SectorSize").Float()
-	ch <- prometheus.MustNewConstMetric(
-		c.nvmeNameSpace, prometheus.GaugeValue, nameSpace, devicePath, genericPath, firmware, modelNumber, serialNumber)
-	ch <- prometheus.MustNewConstMetric(
-		c.nvmeUsedBytes, prometheus.GaugeValue, usedBytes, devicePath, genericPath, firmware, modelNumber, serialNumber)
-	ch <- prometheus.MustNewConstMetric(
-		c.nvmeMaximumLba, prometheus.GaugeValue, maximumLba, devicePath, genericPath, firmware, modelNumber, serialNumber)
-	ch <- prometheus.MustNewConstMetric(
-		c.nvmePhysicalSize, prometheus.GaugeValue, physicalSize, devicePath, genericPath, firmware, modelNumber, serialNumber)
-	ch <- prometheus.MustNewConstMetric(
-		c.nvmeSectorSize, prometheus.GaugeValue, sectorSize, devicePath, genericPath, firmware, modelNumber, serialNumber)
+	primary := c.identity.primaryLabel(identity, device.Get("NameSpace").String())
+	infoLabels := append([]string{primary, genericPath, firmware, modelNumber, serialNumber},
+		c.identity.extraLabels(identity, false)...)
+	ch <- prometheus.MustNewConstMetric(c.nvmeDeviceInfo, prometheus.GaugeValue, 1, infoLabels...)
+
+	// The size gauges only carry the device label so that firmware updates do
+	// not start new series, unless the old layout was asked for.
+	labels := c.identity.labelValues(identity, device.Get("NameSpace").String())
+	if c.identity.legacyInfo {
+		labels = infoLabels
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.nvmeNameSpace, prometheus.GaugeValue, nameSpace, labels...)
+
+	// Devices added by the hotplug watcher have no used bytes until the next
+	// nvme list enumeration.
+	if usedBytes.Exists() {
+		ch <- prometheus.MustNewConstMetric(c.nvmeUsedBytes, prometheus.GaugeValue, usedBytes.Float(), labels...)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.nvmeMaximumLba, prometheus.GaugeValue, maximumLba, labels...)
+	ch <- prometheus.MustNewConstMetric(c.nvmePhysicalSize, prometheus.GaugeValue, physicalSize, labels...)
+	ch <- prometheus.MustNewConstMetric(c.nvmeSectorSize, prometheus.GaugeValue, sectorSize, labels...)
 }
 
-func (c *nvmeCollector) sendSmartLogMetrics(ch chan<- prometheus.Metric, metrics []gjson.Result, device string) {
+func (c *nvmeCollector) sendSmartLogMetrics(ch chan<- prometheus.Metric, metrics []gjson.Result, labels []string) {
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeCriticalWarning, prometheus.GaugeValue, metrics[0].Float(), device)
+		c.nvmeCriticalWarning, prometheus.GaugeValue, metrics[0].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeTemperature, prometheus.GaugeValue, metrics[1].Float(), device)
+		c.nvmeTemperature, prometheus.GaugeValue, metrics[1].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeAvailSpare, prometheus.GaugeValue, metrics[2].Float(), device)
+		c.nvmeAvailSpare, prometheus.GaugeValue, metrics[2].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeSpareThresh, prometheus.GaugeValue, metrics[3].Float(), device)
+		c.nvmeSpareThresh, prometheus.GaugeValue, metrics[3].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePercentUsed, prometheus.GaugeValue, metrics[4].Float(), device)
+		c.nvmePercentUsed, prometheus.GaugeValue, metrics[4].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeEnduranceGrpCriticalWarningSummary, prometheus.GaugeValue, metrics[5].Float(), device)
+		c.nvmeEnduranceGrpCriticalWarningSummary, prometheus.GaugeValue, metrics[5].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeDataUnitsRead, prometheus.CounterValue, metrics[6].Float(), device)
+		c.nvmeDataUnitsRead, prometheus.CounterValue, metrics[6].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeDataUnitsWritten, prometheus.CounterValue, metrics[7].Float(), device)
+		c.nvmeDataUnitsWritten, prometheus.CounterValue, metrics[7].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeHostReadCommands, prometheus.CounterValue, metrics[8].Float(), device)
+		c.nvmeHostReadCommands, prometheus.CounterValue, metrics[8].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeHostWriteCommands, prometheus.CounterValue, metrics[9].Float(), device)
+		c.nvmeHostWriteCommands, prometheus.CounterValue, metrics[9].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeControllerBusyTime, prometheus.CounterValue, metrics[10].Float(), device)
+		c.nvmeControllerBusyTime, prometheus.CounterValue, metrics[10].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePowerCycles, prometheus.CounterValue, metrics[11].Float(), device)
+		c.nvmePowerCycles, prometheus.CounterValue, metrics[11].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePowerOnHours, prometheus.CounterValue, metrics[12].Float(), device)
+		c.nvmePowerOnHours, prometheus.CounterValue, metrics[12].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeUnsafeShutdowns, prometheus.CounterValue, metrics[13].Float(), device)
+		c.nvmeUnsafeShutdowns, prometheus.CounterValue, metrics[13].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeMediaErrors, prometheus.CounterValue, metrics[14].Float(), device)
+		c.nvmeMediaErrors, prometheus.CounterValue, metrics[14].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeNumErrLogEntries, prometheus.CounterValue, metrics[15].Float(), device)
+		c.nvmeNumErrLogEntries, prometheus.CounterValue, metrics[15].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeWarningTempTime, prometheus.CounterValue, metrics[16].Float(), device)
+		c.nvmeWarningTempTime, prometheus.CounterValue, metrics[16].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeCriticalCompTime, prometheus.CounterValue, metrics[17].Float(), device)
+		c.nvmeCriticalCompTime, prometheus.CounterValue, metrics[17].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeThmTemp1TransCount, prometheus.CounterValue, metrics[18].Float(), device)
+		c.nvmeThmTemp1TransCount, prometheus.CounterValue, metrics[18].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeThmTemp2TransCount, prometheus.CounterValue, metrics[19].Float(), device)
+		c.nvmeThmTemp2TransCount, prometheus.CounterValue, metrics[19].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeThmTemp1TotalTime, prometheus.CounterValue, metrics[20].Float(), device)
+		c.nvmeThmTemp1TotalTime, prometheus.CounterValue, metrics[20].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeThmTemp2TotalTime, prometheus.CounterValue, metrics[21].Float(), device)
+		c.nvmeThmTemp2TotalTime, prometheus.CounterValue, metrics[21].Float(), labels...)
 }
 
-func (c *nvmeCollector) sendOcpSmartLogMetrics(ch chan<- prometheus.Metric, metrics []gjson.Result, device string) {
+func (c *nvmeCollector) sendOcpSmartLogMetrics(ch chan<- prometheus.Metric, metrics []gjson.Result, labels []string) {
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePhysicalMediaUnitsWrittenHi, prometheus.CounterValue, metrics[0].Float(), device)
+		c.nvmePhysicalMediaUnitsWrittenHi, prometheus.CounterValue, metrics[0].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePhysicalMediaUnitsWrittenLo, prometheus.CounterValue, metrics[1].Float(), device)
+		c.nvmePhysicalMediaUnitsWrittenLo, prometheus.CounterValue, metrics[1].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePhysicalMediaUnitsReadHi, prometheus.CounterValue, metrics[2].Float(), device)
+		c.nvmePhysicalMediaUnitsReadHi, prometheus.CounterValue, metrics[2].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePhysicalMediaUnitsReadLo, prometheus.CounterValue, metrics[3].Float(), device)
+		c.nvmePhysicalMediaUnitsReadLo, prometheus.CounterValue, metrics[3].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeBadUserNandBlocksRaw, prometheus.CounterValue, metrics[4].Float(), device)
+		c.nvmeBadUserNandBlocksRaw, prometheus.CounterValue, metrics[4].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeBadUserNandBlocksNormalized, prometheus.CounterValue, metrics[5].Float(), device)
+		c.nvmeBadUserNandBlocksNormalized, prometheus.CounterValue, metrics[5].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeBadSystemNandBlocksRaw, prometheus.CounterValue, metrics[6].Float(), device)
+		c.nvmeBadSystemNandBlocksRaw, prometheus.CounterValue, metrics[6].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeBadSystemNandBlocksNormalized, prometheus.CounterValue, metrics[7].Float(), device)
+		c.nvmeBadSystemNandBlocksNormalized, prometheus.CounterValue, metrics[7].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeXorRecoveryCount, prometheus.CounterValue, metrics[8].Float(), device)
+		c.nvmeXorRecoveryCount, prometheus.CounterValue, metrics[8].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeUncorrectableReadErrorCount, prometheus.CounterValue, metrics[9].Float(), device)
+		c.nvmeUncorrectableReadErrorCount, prometheus.CounterValue, metrics[9].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeSoftEccErrorCount, prometheus.CounterValue, metrics[10].Float(), device)
+		c.nvmeSoftEccErrorCount, prometheus.CounterValue, metrics[10].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeEndToEndDetectedErrors, prometheus.CounterValue, metrics[11].Float(), device)
+		c.nvmeEndToEndDetectedErrors, prometheus.CounterValue, metrics[11].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeEndToEndCorrectedErrors, prometheus.CounterValue, metrics[12].Float(), device)
+		c.nvmeEndToEndCorrectedErrors, prometheus.CounterValue, metrics[12].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeSystemDataPercentUsed, prometheus.GaugeValue, metrics[13].Float(), device)
+		c.nvmeSystemDataPercentUsed, prometheus.GaugeValue, metrics[13].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeRefreshCounts, prometheus.CounterValue, metrics[14].Float(), device)
+		c.nvmeRefreshCounts, prometheus.CounterValue, metrics[14].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeMaxUserDataEraseCounts, prometheus.CounterValue, metrics[15].Float(), device)
+		c.nvmeMaxUserDataEraseCounts, prometheus.CounterValue, metrics[15].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeMinUserDataEraseCounts, prometheus.CounterValue, metrics[16].Float(), device)
+		c.nvmeMinUserDataEraseCounts, prometheus.CounterValue, metrics[16].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeNumberOfThermalThrottlingEvents, prometheus.CounterValue, metrics[17].Float(), device)
+		c.nvmeNumberOfThermalThrottlingEvents, prometheus.CounterValue, metrics[17].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeCurrentThrottlingStatus, prometheus.GaugeValue, metrics[18].Float(), device)
+		c.nvmeCurrentThrottlingStatus, prometheus.GaugeValue, metrics[18].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePcieCorrectableErrorCount, prometheus.CounterValue, metrics[19].Float(), device)
+		c.nvmePcieCorrectableErrorCount, prometheus.CounterValue, metrics[19].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeIncompleteShutdowns, prometheus.CounterValue, metrics[20].Float(), device)
+		c.nvmeIncompleteShutdowns, prometheus.CounterValue, metrics[20].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePercentFreeBlocks, prometheus.GaugeValue, metrics[21].Float(), device)
+		c.nvmePercentFreeBlocks, prometheus.GaugeValue, metrics[21].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeCapacitorHealth, prometheus.GaugeValue, metrics[22].Float(), device)
+		c.nvmeCapacitorHealth, prometheus.GaugeValue, metrics[22].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeUnalignedIo, prometheus.CounterValue, metrics[23].Float(), device)
+		c.nvmeUnalignedIo, prometheus.CounterValue, metrics[23].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeSecurityVersionNumber, prometheus.GaugeValue, metrics[24].Float(), device)
+		c.nvmeSecurityVersionNumber, prometheus.GaugeValue, metrics[24].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeNuseNamespaceUtilization, prometheus.GaugeValue, metrics[25].Float(), device)
+		c.nvmeNuseNamespaceUtilization, prometheus.GaugeValue, metrics[25].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePlpStartCount, prometheus.CounterValue, metrics[26].Float(), device)
+		c.nvmePlpStartCount, prometheus.CounterValue, metrics[26].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeEnduranceEstimate, prometheus.GaugeValue, metrics[27].Float(), device)
+		c.nvmeEnduranceEstimate, prometheus.GaugeValue, metrics[27].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeLogPageVersion, prometheus.GaugeValue, metrics[28].Float(), device)
+		c.nvmeLogPageVersion, prometheus.GaugeValue, metrics[28].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeLogPageGUID, prometheus.GaugeValue, metrics[29].Float(), device)
+		c.nvmeLogPageGUID, prometheus.GaugeValue, metrics[29].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeErrataVersionField, prometheus.GaugeValue, metrics[30].Float(), device)
+		c.nvmeErrataVersionField, prometheus.GaugeValue, metrics[30].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePointVersionField, prometheus.GaugeValue, metrics[31].Float(), device)
+		c.nvmePointVersionField, prometheus.GaugeValue, metrics[31].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeMinorVersionField, prometheus.GaugeValue, metrics[32].Float(), device)
+		c.nvmeMinorVersionField, prometheus.GaugeValue, metrics[32].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeMajorVersionField, prometheus.GaugeValue, metrics[33].Float(), device)
+		c.nvmeMajorVersionField, prometheus.GaugeValue, metrics[33].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmeNvmeErrataVersion, prometheus.GaugeValue, metrics[34].Float(), device)
+		c.nvmeNvmeErrataVersion, prometheus.GaugeValue, metrics[34].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePcieLinkRetrainingCount, prometheus.CounterValue, metrics[35].Float(), device)
+		c.nvmePcieLinkRetrainingCount, prometheus.CounterValue, metrics[35].Float(), labels...)
 	ch <- prometheus.MustNewConstMetric(
-		c.nvmePowerStateChangeCount, prometheus.CounterValue, metrics[36].Float(), device)
+		c.nvmePowerStateChangeCount, prometheus.CounterValue, metrics[36].Float(), labels...)
+}
+
+// nvmeCliVersion checks for the nvme-cli executable and returns its
+// major.minor version.
+func nvmeCliVersion() (string, error) {
+	_, err := exec.LookPath("nvme")
+	if err != nil {
+		return "", fmt.Errorf("cannot find NVMe cli command in path: %w", err)
+	}
+
+	err = checkCommandAllowed("nvme", []string{"--version"})
+	if err != nil {
+		return "", err
+	}
+
+	command := exec.Command("nvme", "--version")
+
+	out, err := command.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("error running nvme --version command: %w", err)
+	}
+
+	re := regexp.MustCompile(`nvme version (\d+\.\d+)\.\d+`)
+
+	match := re.FindStringSubmatch(string(out))
+	if match == nil {
+		return "", fmt.Errorf("unable to find NVMe CLI version in output: %s", string(out))
+	}
+
+	return match[1], nil
+}
+
+// checkLocalCollection verifies this process can run nvme-cli itself.
+func checkLocalCollection() error {
+	err := checkPrivileges()
+	if err != nil {
+		return err
+	}
+
+	version, err := nvmeCliVersion()
+	if err != nil {
+		return err
+	}
+
+	if !isSupportedVersion(version) {
+		log.Printf("NVMe cli version %s not supported, supported versions are: %v", version, _supportedVersions)
+	}
+
+	return nil
 }
 
 func main() {
+	err := checkQueriesAllowed()
+	if err != nil {
+		log.Fatalf("Error: %s\n", err)
+	}
+
+	if len(os.Args) > 1 {
+		if subcommand, ok := _subcommands[os.Args[1]]; ok {
+			os.Exit(subcommand(os.Args[2:]))
+		}
+	}
+
 	flag.Usage = func() {
 		fmt.Println("nvme_exporter - Exports NVMe smart-log and smart-ocp-log metrics in Prometheus format")
 		fmt.Println("Validated with nvme smart-log field descriptions can be found on page 209 of:")
@@ -828,57 +980,222 @@ func main() {
 		fmt.Println("https://www.opencompute.org/documents/datacenter-nvme-ssd-specification-v2-5-pdf */")
 		fmt.Printf("It has been tested with nvme-cli versions:%v\n", _supportedVersions)
 		fmt.Println("Usage: nvme_exporter [options]")
+		fmt.Println("       nvme_exporter list|dump|check|check-health|compliance|helper [options]")
 		flag.PrintDefaults()
 	}
 	port := flag.String("port", "9998", "port to listen on")
 	ocp := flag.Bool("ocp", false, "Enable OCP smart log metrics")
+	ocpLatency := flag.Bool("ocp.latency-monitor", false, "Enable OCP latency monitor log (C3) metrics")
+	ocpErrorRecovery := flag.Bool("ocp.error-recovery", false, "Enable OCP error recovery log (C1) metrics")
+	ocpCapabilities := flag.Bool("ocp.device-capabilities", false, "Enable OCP device capabilities log (C4) metrics")
+	ocpRequirements := flag.Bool("ocp.unsupported-requirements", false,
+		"Enable OCP unsupported requirements log (C5) metrics")
+	endurance := flag.Bool("endurance-groups", false, "Enable per endurance group wear and usage metrics")
+	persistentEvents := flag.Bool("persistent-events", false, "Enable persistent event log counters")
+	persistentEventsState := flag.String("persistent-events.state-file", "/var/lib/nvme_exporter/persistent-events.json",
+		"File keeping the last persistent event seen of each controller across restarts")
+	features := flag.Bool("features", false,
+		"Enable power state descriptor, power management, APST, write cache, temperature threshold and queue metrics")
+	thresholdRefresh := flag.Duration("features.threshold-refresh-interval", 10*time.Minute,
+		"Interval between reads of the temperature thresholds of a controller")
+	zns := flag.Bool("zns", false, "Enable zone state and resource metrics of zoned namespaces")
+	sanitize := flag.Bool("sanitize", false,
+		"Enable sanitize status and format progress metrics, skipping other log pages of sanitizing devices")
+	telemetry := flag.Bool("telemetry", false, "Enable controller-initiated telemetry data availability metrics")
+	captureDir := flag.String("telemetry.capture-dir", "",
+		"Directory for telemetry logs captured through the API, capturing is disabled if empty")
+	captureTokenFile := flag.String("telemetry.capture-token-file", "",
+		"File holding the bearer token required by the telemetry capture endpoint")
+	captureMaxBytes := flag.Int64("telemetry.capture-max-bytes", 512<<20, "Maximum size of a captured telemetry log")
+	captureInterval := flag.Duration("telemetry.capture-interval", time.Hour,
+		"Minimum interval between telemetry captures of a device")
 	endpoint := flag.String("endpoint", "/metrics", "Specify the endpoint to expose metrics")
+	hotplug := flag.Bool("hotplug", false, "Track devices with udev events instead of running nvme list on every scrape")
+	hotplugRescan := flag.Duration("hotplug.rescan-interval", 10*time.Minute,
+		"Interval of the full nvme list enumeration when hotplug is enabled")
+	kmsg := flag.Bool("kmsg", false, "Enable kernel nvme driver event metrics from the kernel log")
+	kmsgPath := flag.String("kmsg.path", "/dev/kmsg", "Path of the kernel log device")
+	sysfs := flag.Bool("sysfs", false, "Enable controller metrics from sysfs, needing neither nvme-cli nor root")
+	sysfsPath := flag.String("path.sysfs", "/sys", "Mount point of the sysfs filesystem")
+	pcie := flag.Bool("pcie", false, "Enable PCIe link and AER metrics from sysfs")
+	diskstats := flag.Bool("diskstats", false, "Enable block layer I/O statistics and queue settings of NVMe namespaces")
+	usage := flag.Bool("usage", false, "Enable metrics mapping namespaces to mounts, md arrays and LVM volume groups")
+	kubernetes := flag.Bool("kubernetes", false, "Enable metrics mapping namespaces to Kubernetes local PersistentVolumes")
+	kubernetesNode := flag.String("kubernetes.node", os.Getenv("NODE_NAME"), "Kubernetes node name of this host")
+	kubernetesRefresh := flag.Duration("kubernetes.refresh-interval", time.Minute,
+		"Interval between Kubernetes API queries")
+	procfsPath := flag.String("path.procfs", "/proc", "Mount point of the proc filesystem")
+	helperSocket := flag.String("helper.socket", "", "Run nvme queries through the privileged helper on this socket")
+	labelIdentity := flag.String("label.identity", identityDevice,
+		"Value of the device label: device (kernel name), serial, wwid or path (/dev/disk/by-id link)")
+	identityLabels := flag.Bool("label.identity-labels", false,
+		"Add serial number, EUI64, NGUID, UUID and by-id path labels to every per-device metric")
+	legacyInfoLabels := flag.Bool("legacy-info-labels", false,
+		"Keep the nvme_device_info labels on the namespace and size gauges, as in earlier releases")
 	flag.Parse()
 
 	if !strings.HasPrefix(*endpoint, "/") {
 		*endpoint = "/" + *endpoint
 	}
 
-	// check user
-	currentUser, err := user.Current()
+	identity := _defaultIdentity
+	identity.primary = *labelIdentity
+	identity.labels = *identityLabels
+	identity.legacyInfo = *legacyInfoLabels
+	identity.sysRoot = *sysfsPath
+
+	err = identity.validate()
 	if err != nil {
-		log.Fatalf("Error getting current user  %s\n", err)
+		log.Fatalf("Error: %s\n", err)
 	}
 
-	if currentUser.Username != "root" {
-		log.Fatalln("Error: you must be root to use nvme-cli")
+	collector := newNvmeCollector(*ocp, identity)
+	if *ocpLatency {
+		collector.logPages = append(collector.logPages, newOcpLatencyCollector(identity.labelNames()))
 	}
 
-	// check for nvme-cli executable
-	_, err = exec.LookPath("nvme")
-	if err != nil {
-		log.Fatalf("Cannot find NVMe cli command in path: %s\n", err)
+	if *ocpErrorRecovery {
+		collector.logPages = append(collector.logPages, newOcpErrorRecoveryCollector(identity.labelNames()))
 	}
-	// check for nvme-cli version
-	command := exec.Command("nvme", "--version")
 
-	out, err := command.CombinedOutput()
-	if err != nil {
-		log.Fatalf("error running nvme --version command: %s", err)
+	if *ocpCapabilities {
+		collector.logPages = append(collector.logPages, newOcpCapabilitiesCollector(identity.labelNames()))
 	}
 
-	re := regexp.MustCompile(`nvme version (\d+\.\d+)\.\d+`)
-	match := re.FindStringSubmatch(string(out))
+	if *ocpRequirements {
+		collector.logPages = append(collector.logPages, newOcpRequirementsCollector(identity.labelNames()))
+	}
 
-	if match != nil {
-		version := match[1]
-		if !isSupportedVersion(version) {
-			log.Printf("NVMe cli version %s not supported, supported versions are: %v", version, _supportedVersions)
+	if *endurance {
+		collector.logPages = append(collector.logPages, newEnduranceCollector(identity.labelNames()))
+	}
+
+	if *persistentEvents {
+		events, err := newPersistentEventsCollector(identity.labelNames(), *persistentEventsState)
+		if err != nil {
+			log.Fatalf("Error: %s\n", err)
 		}
+
+		collector.logPages = append(collector.logPages, events)
+	}
+
+	if *features {
+		collector.logPages = append(collector.logPages, newFeaturesCollector(identity.labelNames(), *thresholdRefresh))
+	}
+
+	if *zns {
+		collector.logPages = append(collector.logPages, newZnsCollector(identity.labelNames(), *sysfsPath))
+	}
+
+	if *sanitize {
+		collector.sanitize = true
+		collector.logPages = append(collector.logPages, newSanitizeCollector(identity.labelNames()))
+	}
+
+	if *telemetry {
+		collector.logPages = append(collector.logPages, newTelemetryCollector(identity.labelNames()))
+	}
+
+	if *helperSocket != "" {
+		collector.run = helperClient{socket: *helperSocket}.run
 	} else {
-		log.Fatalf("Unable to find NVMe CLI version in output: %s", string(out))
+		err = checkLocalCollection()
+	}
+
+	// Without nvme-cli the collector is not registered, and neither is the
+	// API or the telemetry capture reading from it.
+	nvmeRegistered := err == nil
+
+	switch {
+	case nvmeRegistered:
+		prometheus.MustRegister(collector)
+	case *sysfs || *pcie || *diskstats || *usage || *kubernetes:
+		log.Printf("Cannot run nvme-cli, only exporting kernel metrics: %s\n", err)
+	default:
+		log.Fatalf("Error: %s\n", err)
+	}
+
+	prometheus.MustRegister(_rejectedCommands)
+
+	if *sysfs {
+		prometheus.MustRegister(newSysfsCollector(identity))
+	}
+
+	if *pcie {
+		prometheus.MustRegister(newPcieCollector(identity))
+	}
+
+	if *diskstats {
+		prometheus.MustRegister(newDiskstatsCollector(*procfsPath, identity))
+	}
+
+	if *usage {
+		prometheus.MustRegister(newUsageCollector(*procfsPath, identity))
+	}
+
+	if *kubernetes {
+		client, err := newInClusterClient()
+		if err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+
+		prometheus.MustRegister(newKubernetesCollector(
+			client, *kubernetesNode, newUsageCollector(*procfsPath, identity), *kubernetesRefresh))
+	}
+
+	if *hotplug {
+		collector.hotplug = newHotplugWatcher(*hotplugRescan, *sysfsPath)
+
+		err = collector.hotplug.listen()
+		if err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+
+		prometheus.MustRegister(collector.hotplug.events)
+	}
+
+	if *kmsg {
+		kmsgCollector := newKmsgCollector(identity)
+
+		err = kmsgCollector.tail(*kmsgPath)
+		if err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+
+		prometheus.MustRegister(kmsgCollector)
 	}
 
-	prometheus.MustRegister(newNvmeCollector(*ocp))
 	http.Handle(*endpoint, promhttp.Handler())
+
+	if nvmeRegistered {
+		registerAPIHandlers(http.DefaultServeMux, collector)
+	}
+
+	switch {
+	case *captureDir == "":
+	case !nvmeRegistered:
+		log.Println("Telemetry capture is disabled without nvme-cli")
+	default:
+		if *helperSocket != "" {
+			log.Fatalln("Error: telemetry capture is not available through the privileged helper")
+		}
+
+		capture, err := newTelemetryCapture(collector, *captureDir, *captureTokenFile, *captureMaxBytes, *captureInterval)
+		if err != nil {
+			log.Fatalf("Error: %s\n", err)
+		}
+
+		registerTelemetryCapture(http.DefaultServeMux, capture)
+		log.Printf("newNvmeCollector is capturing telemetry logs to: %s\n", *captureDir)
+	}
+
 	log.Printf("Starting newNvmeCollector on port: %s, metrics endpoint: %s\n", *port, *endpoint)
 	log.Printf("newNvmeCollector is collecting OCP smart-log metrics: %t\n", *ocp)
 
+	if *helperSocket != "" {
+		log.Printf("newNvmeCollector is using the privileged helper on socket: %s\n", *helperSocket)
+	}
+
 	server := &http.Server{
 		Addr:              ":" + *port,
 		ReadHeaderTimeout: 3 * time.Second,
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// latencyBuckets is the number of latency buckets in the C3 log page.
+	latencyBuckets = 4
+	// latencyTimestampLayout is how nvme-cli prints the C3 time stamps,
+	// which the device reports in milliseconds since the epoch.
+	latencyTimestampLayout = "2006-01-02T15:04:05.000 GMT"
+)
+
+// _latencyWindows maps the nvme-cli key prefix of each C3 window to its label.
+var _latencyWindows = map[string]string{
+	"Active": "active",
+	"Static": "static",
+}
+
+// _latencyOperations maps the nvme-cli operation keys to the operation label.
+var _latencyOperations = map[string]string{
+	"Read":  "read",
+	"Write": "write",
+	"Trim":  "deallocate",
+}
+
+// ocpLatencyCollector exports the OCP latency monitor log page (C3): command
+// counts per latency bucket and the highest latency measured in each bucket.
+// The active window counts are counters: they restart from zero whenever the
+// bucket timer expires, which rate() handles as a counter reset. The static
+// window holds a copy of the counts of the last expired active window, which
+// goes up and down from one window to the next, so it is a gauge. They are
+// not a histogram, commands faster than threshold A are not counted at all.
+type ocpLatencyCollector struct {
+	nvmeOcpLatencyBucketCommands    *prometheus.Desc
+	nvmeOcpLatencyStaticCommands    *prometheus.Desc
+	nvmeOcpLatencyMaxLatency        *prometheus.Desc
+	nvmeOcpLatencyMaxLatencyTime    *prometheus.Desc
+	nvmeOcpLatencyMonitorConfigInfo *prometheus.Desc
+	nvmeOcpLatencyMonitorStampUnits *prometheus.Desc
+}
+
+func newOcpLatencyCollector(labels []string) *ocpLatencyCollector {
+	bucketLabels := withLabels(labels, "window", "bucket", "operation")
+
+	return &ocpLatencyCollector{
+		nvmeOcpLatencyBucketCommands: prometheus.NewDesc(
+			"nvme_ocp_latency_monitor_bucket_commands_total",
+			"Commands whose latency fell in the bucket during the active window",
+			bucketLabels,
+			nil,
+		),
+		nvmeOcpLatencyStaticCommands: prometheus.NewDesc(
+			"nvme_ocp_latency_monitor_bucket_commands",
+			"Commands whose latency fell in the bucket during the last expired window",
+			bucketLabels,
+			nil,
+		),
+		nvmeOcpLatencyMaxLatency: prometheus.NewDesc(
+			"nvme_ocp_latency_monitor_max_latency_seconds",
+			"Highest latency measured in the bucket during the window",
+			bucketLabels,
+			nil,
+		),
+		nvmeOcpLatencyMaxLatencyTime: prometheus.NewDesc(
+			"nvme_ocp_latency_monitor_max_latency_timestamp_seconds",
+			"Time the highest latency of the bucket was measured, in seconds since the epoch",
+			bucketLabels,
+			nil,
+		),
+		nvmeOcpLatencyMonitorConfigInfo: prometheus.NewDesc(
+			"nvme_ocp_latency_monitor_config_info",
+			"Active latency monitor configuration, thresholds bound the buckets",
+			withLabels(labels, "feature_status", "latency_config", "threshold_a_ms",
+				"threshold_b_ms", "threshold_c_ms", "threshold_d_ms", "bucket_timer_threshold_minutes",
+				"minimum_window_ms"),
+			nil,
+		),
+		nvmeOcpLatencyMonitorStampUnits: prometheus.NewDesc(
+			"nvme_ocp_latency_monitor_stamp_units",
+			"Bitmask of the buckets whose time stamps come from the device rather than the host",
+			withLabels(labels, "window"),
+			nil,
+		),
+	}
+}
+
+func (c *ocpLatencyCollector) queries(deviceSnapshot) []string {
+	return []string{"ocp-latency-monitor-log"}
+}
+
+func (c *ocpLatencyCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeOcpLatencyBucketCommands
+	ch <- c.nvmeOcpLatencyStaticCommands
+	ch <- c.nvmeOcpLatencyMaxLatency
+	ch <- c.nvmeOcpLatencyMaxLatencyTime
+	ch <- c.nvmeOcpLatencyMonitorConfigInfo
+	ch <- c.nvmeOcpLatencyMonitorStampUnits
+}
+
+// parseLatencyTimestamp parses a C3 time stamp, reporting false for the "NA"
+// nvme-cli prints for buckets without a measurement.
+func parseLatencyTimestamp(stamp gjson.Result) (float64, bool) {
+	if stamp.Type == gjson.Number {
+		return stamp.Float() / millisecondsPerSec, true
+	}
+
+	parsed, err := time.Parse(latencyTimestampLayout, stamp.String())
+	if err != nil {
+		return 0, false
+	}
+
+	return float64(parsed.UnixMilli()) / millisecondsPerSec, true
+}
+
+func (c *ocpLatencyCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	latencyLog, ok := device.logs["ocp-latency-monitor-log"]
+	if !ok || !latencyLog.IsObject() {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.nvmeOcpLatencyMonitorConfigInfo, prometheus.GaugeValue, 1,
+		withLabels(labels,
+			latencyLog.Get("Feature Status").String(),
+			latencyLog.Get("Active Latency Configuration").String(),
+			latencyLog.Get("Active Threshold A").String(),
+			latencyLog.Get("Active Threshold B").String(),
+			latencyLog.Get("Active Threshold C").String(),
+			latencyLog.Get("Active Threshold D").String(),
+			latencyLog.Get("Active Bucket Timer Threshold").String(),
+			latencyLog.Get("Active Latency Minimum Window").String())...)
+
+	for prefix, window := range _latencyWindows {
+		if units := latencyLog.Get(prefix + " Latency Stamp Units"); units.Exists() {
+			ch <- prometheus.MustNewConstMetric(c.nvmeOcpLatencyMonitorStampUnits, prometheus.GaugeValue,
+				units.Float(), withLabels(labels, window)...)
+		}
+
+		for bucket := 0; bucket < latencyBuckets; bucket++ {
+			counters := latencyLog.Get(gjson.Escape(fmt.Sprintf("%s Bucket Counter: Bucket %d", prefix, bucket)))
+			latencies := latencyLog.Get(gjson.Escape(fmt.Sprintf("%s Measured Latency: Bucket %d", prefix, bucket)))
+			stamps := latencyLog.Get(gjson.Escape(fmt.Sprintf("%s Latency Time Stamp: Bucket %d", prefix, bucket)))
+
+			for key, operation := range _latencyOperations {
+				bucketLabels := withLabels(labels, window, strconv.Itoa(bucket), operation)
+
+				switch count := counters.Get(key); {
+				case !count.Exists():
+				case prefix == "Active":
+					ch <- prometheus.MustNewConstMetric(
+						c.nvmeOcpLatencyBucketCommands, prometheus.CounterValue, count.Float(), bucketLabels...)
+				default:
+					ch <- prometheus.MustNewConstMetric(
+						c.nvmeOcpLatencyStaticCommands, prometheus.GaugeValue, count.Float(), bucketLabels...)
+				}
+
+				if latency := latencies.Get(key); latency.Exists() {
+					ch <- prometheus.MustNewConstMetric(c.nvmeOcpLatencyMaxLatency, prometheus.GaugeValue,
+						latency.Float()/millisecondsPerSec, bucketLabels...)
+				}
+
+				if stamp, ok := parseLatencyTimestamp(stamps.Get(key)); ok {
+					ch <- prometheus.MustNewConstMetric(
+						c.nvmeOcpLatencyMaxLatencyTime, prometheus.GaugeValue, stamp, bucketLabels...)
+				}
+			}
+		}
+	}
+}
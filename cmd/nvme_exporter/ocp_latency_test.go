@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// testLogPages registers a log page collector sending the metrics of a device
+// with the logs, labelled with the default identity.
+type testLogPages struct {
+	logPageCollector
+
+	device deviceSnapshot
+}
+
+func newTestLogPages(collector logPageCollector, logs map[string]string) testLogPages {
+	device := deviceSnapshot{logs: map[string]gjson.Result{}}
+	for query, output := range logs {
+		device.logs[query] = gjson.Parse(output)
+	}
+
+	return testLogPages{logPageCollector: collector, device: device}
+}
+
+func (c testLogPages) Describe(ch chan<- *prometheus.Desc) {
+	c.describe(ch)
+}
+
+func (c testLogPages) Collect(ch chan<- prometheus.Metric) {
+	c.send(ch, c.device, []string{testDevice})
+}
+
+const testLatencyLog = `{
+  "Feature Status": 3,
+  "Active Bucket Timer": 10,
+  "Active Bucket Timer Threshold": 60,
+  "Active Threshold A": 5,
+  "Active Threshold B": 10,
+  "Active Threshold C": 50,
+  "Active Threshold D": 100,
+  "Active Latency Configuration": 4095,
+  "Active Latency Minimum Window": 100,
+  "Active Bucket Counter: Bucket 0": {"Read": 100, "Write": 50, "Trim": 1},
+  "Active Bucket Counter: Bucket 1": {"Read": 10, "Write": 5, "Trim": 0},
+  "Active Latency Time Stamp: Bucket 0": {"Read": "2026-10-18T10:00:00.123 GMT", "Write": "NA", "Trim": "NA"},
+  "Active Measured Latency: Bucket 0": {"Read": 7, "Write": 0, "Trim": 0},
+  "Active Latency Stamp Units": 1,
+  "Static Bucket Counter: Bucket 0": {"Read": 900, "Write": 400, "Trim": 2},
+  "Static Latency Stamp Units": 0
+}`
+
+func TestOcpLatencyCollector(t *testing.T) {
+	c := newTestLogPages(newOcpLatencyCollector([]string{"device"}),
+		map[string]string{"ocp-latency-monitor-log": testLatencyLog})
+
+	expected := `
+# HELP nvme_ocp_latency_monitor_bucket_commands Commands whose latency fell in the bucket during the last expired window
+# TYPE nvme_ocp_latency_monitor_bucket_commands gauge
+nvme_ocp_latency_monitor_bucket_commands{bucket="0",device="/dev/nvme0n1",operation="deallocate",window="static"} 2
+nvme_ocp_latency_monitor_bucket_commands{bucket="0",device="/dev/nvme0n1",operation="read",window="static"} 900
+nvme_ocp_latency_monitor_bucket_commands{bucket="0",device="/dev/nvme0n1",operation="write",window="static"} 400
+# HELP nvme_ocp_latency_monitor_bucket_commands_total Commands whose latency fell in the bucket during the active window
+# TYPE nvme_ocp_latency_monitor_bucket_commands_total counter
+nvme_ocp_latency_monitor_bucket_commands_total{bucket="0",device="/dev/nvme0n1",operation="deallocate",window="active"} 1
+nvme_ocp_latency_monitor_bucket_commands_total{bucket="0",device="/dev/nvme0n1",operation="read",window="active"} 100
+nvme_ocp_latency_monitor_bucket_commands_total{bucket="0",device="/dev/nvme0n1",operation="write",window="active"} 50
+nvme_ocp_latency_monitor_bucket_commands_total{bucket="1",device="/dev/nvme0n1",operation="deallocate",window="active"} 0
+nvme_ocp_latency_monitor_bucket_commands_total{bucket="1",device="/dev/nvme0n1",operation="read",window="active"} 10
+nvme_ocp_latency_monitor_bucket_commands_total{bucket="1",device="/dev/nvme0n1",operation="write",window="active"} 5
+# HELP nvme_ocp_latency_monitor_max_latency_timestamp_seconds Time the highest latency of the bucket was measured, in seconds since the epoch
+# TYPE nvme_ocp_latency_monitor_max_latency_timestamp_seconds gauge
+nvme_ocp_latency_monitor_max_latency_timestamp_seconds{bucket="0",device="/dev/nvme0n1",operation="read",window="active"} 1.792317600123e+09
+# HELP nvme_ocp_latency_monitor_stamp_units Bitmask of the buckets whose time stamps come from the device rather than the host
+# TYPE nvme_ocp_latency_monitor_stamp_units gauge
+nvme_ocp_latency_monitor_stamp_units{device="/dev/nvme0n1",window="active"} 1
+nvme_ocp_latency_monitor_stamp_units{device="/dev/nvme0n1",window="static"} 0
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nvme_ocp_latency_monitor_bucket_commands", "nvme_ocp_latency_monitor_bucket_commands_total",
+		"nvme_ocp_latency_monitor_max_latency_timestamp_seconds", "nvme_ocp_latency_monitor_stamp_units")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+// recoveryTimeUnit is the unit of the C1 wait times and timeouts, 100 ms.
+const recoveryTimeUnit = 0.1
+
+// logUint returns an integer log field that nvme-cli prints either as a
+// number or as a hex string, and whether it was present.
+func logUint(field gjson.Result) (uint64, bool) {
+	switch field.Type {
+	case gjson.Number:
+		return field.Uint(), true
+	case gjson.String:
+		value, err := strconv.ParseUint(field.String(), 0, 64)
+
+		return value, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// logHex formats an integer log field as hex for info metric labels, or ""
+// when the device did not report it.
+func logHex(field gjson.Result) string {
+	value, ok := logUint(field)
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%#x", value)
+}
+
+// ocpErrorRecoveryCollector exports the OCP error recovery log page (C1). A
+// non-zero panic ID means the device hit a panic and needs the reported
+// recovery actions.
+type ocpErrorRecoveryCollector struct {
+	nvmeOcpPanicID                *prometheus.Desc
+	nvmeOcpErrorRecoveryInfo      *prometheus.Desc
+	nvmeOcpPanicResetWaitTime     *prometheus.Desc
+	nvmeOcpRecoveryAction2Timeout *prometheus.Desc
+}
+
+func newOcpErrorRecoveryCollector(labels []string) *ocpErrorRecoveryCollector {
+	return &ocpErrorRecoveryCollector{
+		nvmeOcpPanicID: prometheus.NewDesc(
+			"nvme_ocp_panic_id",
+			"Panic ID of the last device panic, 0 when no panic occurred",
+			labels,
+			nil,
+		),
+		nvmeOcpErrorRecoveryInfo: prometheus.NewDesc(
+			"nvme_ocp_error_recovery_info",
+			"Panic reset and device recovery actions the device requests after a panic",
+			withLabels(labels, "panic_id", "panic_reset_action", "device_recovery_action_1",
+				"device_recovery_action_2", "device_capabilities"),
+			nil,
+		),
+		nvmeOcpPanicResetWaitTime: prometheus.NewDesc(
+			"nvme_ocp_panic_reset_wait_time_seconds",
+			"Time to wait after a panic before performing the panic reset action",
+			labels,
+			nil,
+		),
+		nvmeOcpRecoveryAction2Timeout: prometheus.NewDesc(
+			"nvme_ocp_device_recovery_action_2_timeout_seconds",
+			"Time to wait for device recovery action 2 to complete",
+			labels,
+			nil,
+		),
+	}
+}
+
+func (c *ocpErrorRecoveryCollector) queries(deviceSnapshot) []string {
+	return []string{"ocp-error-recovery-log"}
+}
+
+func (c *ocpErrorRecoveryCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeOcpPanicID
+	ch <- c.nvmeOcpErrorRecoveryInfo
+	ch <- c.nvmeOcpPanicResetWaitTime
+	ch <- c.nvmeOcpRecoveryAction2Timeout
+}
+
+func (c *ocpErrorRecoveryCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	recoveryLog, ok := device.logs["ocp-error-recovery-log"]
+	if !ok || !recoveryLog.IsObject() {
+		return
+	}
+
+	panicID, ok := logUint(recoveryLog.Get("Panic ID"))
+	if ok {
+		ch <- prometheus.MustNewConstMetric(c.nvmeOcpPanicID, prometheus.GaugeValue, float64(panicID), labels...)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.nvmeOcpErrorRecoveryInfo, prometheus.GaugeValue, 1,
+		withLabels(labels,
+			logHex(recoveryLog.Get("Panic ID")),
+			logHex(recoveryLog.Get("Panic Reset Action")),
+			logHex(recoveryLog.Get("Device Recovery Action 1")),
+			logHex(recoveryLog.Get("Device Recovery Action 2")),
+			logHex(recoveryLog.Get("Device Capabilities")))...)
+
+	if wait, ok := logUint(recoveryLog.Get("Panic Reset Wait Time")); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeOcpPanicResetWaitTime, prometheus.GaugeValue, float64(wait)*recoveryTimeUnit, labels...)
+	}
+
+	if timeout, ok := logUint(recoveryLog.Get("Device Recovery Action 2 Timeout")); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeOcpRecoveryAction2Timeout, prometheus.GaugeValue, float64(timeout)*recoveryTimeUnit, labels...)
+	}
+}
+
+// _ocpCapabilityFields maps the device capabilities log page (C4) fields to
+// the labels of nvme_ocp_device_capabilities_info. The values are the raw
+// support bitmasks, bit 15 set meaning the field is valid.
+var _ocpCapabilityFields = []struct {
+	key   string
+	label string
+}{
+	{"PCI Express Ports", "pcie_ports"},
+	{"OOB Management Support", "oob_management"},
+	{"Write Zeroes Command Support", "write_zeroes"},
+	{"Sanitize Command Support", "sanitize"},
+	{"Dataset Management Command Support", "dataset_management"},
+	{"Write Uncorrectable Command Support", "write_uncorrectable"},
+	{"Fused Operation Support", "fused_operation"},
+	{"Minimum Valid DSSD Power State", "min_dssd_power_state"},
+	{"Log Page Version", "log_page_version"},
+}
+
+// ocpCapabilitiesCollector exports the OCP device capabilities log page (C4).
+type ocpCapabilitiesCollector struct {
+	nvmeOcpDeviceCapabilitiesInfo *prometheus.Desc
+	nvmeOcpDssdPowerState         *prometheus.Desc
+}
+
+func newOcpCapabilitiesCollector(labels []string) *ocpCapabilitiesCollector {
+	infoLabels := withLabels(labels)
+	for _, field := range _ocpCapabilityFields {
+		infoLabels = append(infoLabels, field.label)
+	}
+
+	return &ocpCapabilitiesCollector{
+		nvmeOcpDeviceCapabilitiesInfo: prometheus.NewDesc(
+			"nvme_ocp_device_capabilities_info",
+			"Capabilities the device reports in the OCP device capabilities log page",
+			infoLabels,
+			nil,
+		),
+		nvmeOcpDssdPowerState: prometheus.NewDesc(
+			"nvme_ocp_dssd_power_state_descriptor",
+			"DSSD power state descriptor of the NVMe power state",
+			withLabels(labels, "power_state"),
+			nil,
+		),
+	}
+}
+
+func (c *ocpCapabilitiesCollector) queries(deviceSnapshot) []string {
+	return []string{"ocp-device-capability-log"}
+}
+
+func (c *ocpCapabilitiesCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeOcpDeviceCapabilitiesInfo
+	ch <- c.nvmeOcpDssdPowerState
+}
+
+func (c *ocpCapabilitiesCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	capabilityLog, ok := device.logs["ocp-device-capability-log"]
+	if !ok || !capabilityLog.IsObject() {
+		return
+	}
+
+	infoLabels := withLabels(labels)
+	for _, field := range _ocpCapabilityFields {
+		infoLabels = append(infoLabels, logHex(capabilityLog.Get(field.key)))
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.nvmeOcpDeviceCapabilitiesInfo, prometheus.GaugeValue, 1, infoLabels...)
+
+	for powerState, descriptor := range capabilityLog.Get("DSSD Power State Descriptors").Array() {
+		ch <- prometheus.MustNewConstMetric(c.nvmeOcpDssdPowerState, prometheus.GaugeValue, descriptor.Float(),
+			withLabels(labels, strconv.Itoa(powerState))...)
+	}
+}
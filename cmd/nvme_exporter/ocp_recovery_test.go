@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// testRecoveryLog is an ocp error-recovery-log -o json output after a panic.
+const testRecoveryLog = `{
+  "Panic Reset Wait Time": 50,
+  "Panic Reset Action": "0x2",
+  "Device Recovery Action 1": 4,
+  "Panic ID": "0x1234",
+  "Device Capabilities": "0x3f",
+  "Vendor Specific Recovery Opcode": 0,
+  "Vendor Specific Command CDW12": 0,
+  "Vendor Specific Command CDW13": 0,
+  "Vendor Specific Command Timeout": 0,
+  "Device Recovery Action 2": 1,
+  "Device Recovery Action 2 Timeout": 300,
+  "Log Page Version": 2,
+  "Log page GUID": "0x758059ad49793cb3a07e09e1b9d1fd27"
+}`
+
+// testCapabilityLog is an ocp device-capability-log -o json output with two
+// DSSD power states.
+const testCapabilityLog = `{
+  "PCI Express Ports": "0x8001",
+  "OOB Management Support": "0x8003",
+  "Write Zeroes Command Support": "0x801f",
+  "Sanitize Command Support": "0x800f",
+  "Dataset Management Command Support": "0x8003",
+  "Write Uncorrectable Command Support": "0x8003",
+  "Fused Operation Support": "0x8001",
+  "Minimum Valid DSSD Power State": 1,
+  "DSSD Power State Descriptors": [0, 1],
+  "Log Page Version": 1,
+  "Log page GUID": "0xb7053c914b58495d98c9e1d10d054297"
+}`
+
+func TestLogUint(t *testing.T) {
+	for _, test := range []struct {
+		field string
+		want  uint64
+		ok    bool
+	}{
+		{`{"f":42}`, 42, true},
+		{`{"f":"0x2a"}`, 42, true},
+		{`{"f":"42"}`, 42, true},
+		{`{"f":"0xffffffffffffffff"}`, 1<<64 - 1, true},
+		{`{"f":"NA"}`, 0, false},
+		{`{"f":"-1"}`, 0, false},
+		{`{"f":null}`, 0, false},
+		{`{"f":[1]}`, 0, false},
+		{`{}`, 0, false},
+	} {
+		got, ok := logUint(gjson.Get(test.field, "f"))
+		if got != test.want || ok != test.ok {
+			t.Errorf("logUint(%s) = %d, %v, want %d, %v", test.field, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestLogHex(t *testing.T) {
+	for field, want := range map[string]string{
+		`{"f":0}`:        "0x0",
+		`{"f":255}`:      "0xff",
+		`{"f":"0x8001"}`: "0x8001",
+		`{"f":"NA"}`:     "",
+		`{}`:             "",
+	} {
+		if got := logHex(gjson.Get(field, "f")); got != want {
+			t.Errorf("logHex(%s) = %q, want %q", field, got, want)
+		}
+	}
+}
+
+func TestOcpErrorRecoveryCollector(t *testing.T) {
+	c := newTestLogPages(newOcpErrorRecoveryCollector([]string{"device"}),
+		map[string]string{"ocp-error-recovery-log": testRecoveryLog})
+
+	expected := `
+# HELP nvme_ocp_panic_id Panic ID of the last device panic, 0 when no panic occurred
+# TYPE nvme_ocp_panic_id gauge
+nvme_ocp_panic_id{device="/dev/nvme0n1"} 4660
+# HELP nvme_ocp_error_recovery_info Panic reset and device recovery actions the device requests after a panic
+# TYPE nvme_ocp_error_recovery_info gauge
+nvme_ocp_error_recovery_info{device="/dev/nvme0n1",device_capabilities="0x3f",device_recovery_action_1="0x4",device_recovery_action_2="0x1",panic_id="0x1234",panic_reset_action="0x2"} 1
+# HELP nvme_ocp_panic_reset_wait_time_seconds Time to wait after a panic before performing the panic reset action
+# TYPE nvme_ocp_panic_reset_wait_time_seconds gauge
+nvme_ocp_panic_reset_wait_time_seconds{device="/dev/nvme0n1"} 5
+# HELP nvme_ocp_device_recovery_action_2_timeout_seconds Time to wait for device recovery action 2 to complete
+# TYPE nvme_ocp_device_recovery_action_2_timeout_seconds gauge
+nvme_ocp_device_recovery_action_2_timeout_seconds{device="/dev/nvme0n1"} 30
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+
+	// A device that did not return the log page sends nothing.
+	c = newTestLogPages(newOcpErrorRecoveryCollector([]string{"device"}), nil)
+	if count := testutil.CollectAndCount(c); count != 0 {
+		t.Errorf("sent %d metrics without the log page", count)
+	}
+}
+
+func TestOcpCapabilitiesCollector(t *testing.T) {
+	c := newTestLogPages(newOcpCapabilitiesCollector([]string{"device"}),
+		map[string]string{"ocp-device-capability-log": testCapabilityLog})
+
+	expected := `
+# HELP nvme_ocp_device_capabilities_info Capabilities the device reports in the OCP device capabilities log page
+# TYPE nvme_ocp_device_capabilities_info gauge
+nvme_ocp_device_capabilities_info{dataset_management="0x8003",device="/dev/nvme0n1",fused_operation="0x8001",log_page_version="0x1",min_dssd_power_state="0x1",oob_management="0x8003",pcie_ports="0x8001",sanitize="0x800f",write_uncorrectable="0x8003",write_zeroes="0x801f"} 1
+# HELP nvme_ocp_dssd_power_state_descriptor DSSD power state descriptor of the NVMe power state
+# TYPE nvme_ocp_dssd_power_state_descriptor gauge
+nvme_ocp_dssd_power_state_descriptor{device="/dev/nvme0n1",power_state="0"} 0
+nvme_ocp_dssd_power_state_descriptor{device="/dev/nvme0n1",power_state="1"} 1
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
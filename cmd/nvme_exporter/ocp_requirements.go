@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+// unsupportedRequirementKey prefixes the requirement ID entries nvme-cli
+// prints for the unsupported requirements log page (C5).
+const unsupportedRequirementKey = "Unsupported Requirement List"
+
+// unsupportedRequirements returns the OCP requirement IDs the device does not
+// meet, in log page order.
+func unsupportedRequirements(requirementsLog gjson.Result) []string {
+	var ids []string
+
+	requirementsLog.ForEach(func(key, value gjson.Result) bool {
+		if strings.HasPrefix(key.String(), unsupportedRequirementKey) {
+			if id := strings.Trim(value.String(), " \x00"); id != "" {
+				ids = append(ids, id)
+			}
+		}
+
+		return true
+	})
+
+	return ids
+}
+
+// ocpRequirementsCollector exports the OCP unsupported requirements log page
+// (C5), listing the datacenter NVMe SSD specification requirements the device
+// does not meet.
+type ocpRequirementsCollector struct {
+	nvmeOcpUnsupportedRequirement  *prometheus.Desc
+	nvmeOcpUnsupportedRequirements *prometheus.Desc
+}
+
+func newOcpRequirementsCollector(labels []string) *ocpRequirementsCollector {
+	return &ocpRequirementsCollector{
+		nvmeOcpUnsupportedRequirement: prometheus.NewDesc(
+			"nvme_ocp_unsupported_requirement",
+			"OCP datacenter NVMe SSD specification requirement the device does not meet",
+			withLabels(labels, "requirement_id"),
+			nil,
+		),
+		nvmeOcpUnsupportedRequirements: prometheus.NewDesc(
+			"nvme_ocp_unsupported_requirements",
+			"Number of OCP datacenter NVMe SSD specification requirements the device does not meet",
+			labels,
+			nil,
+		),
+	}
+}
+
+func (c *ocpRequirementsCollector) queries(deviceSnapshot) []string {
+	return []string{"ocp-unsupported-reqs-log"}
+}
+
+func (c *ocpRequirementsCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeOcpUnsupportedRequirement
+	ch <- c.nvmeOcpUnsupportedRequirements
+}
+
+func (c *ocpRequirementsCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	requirementsLog, ok := device.logs["ocp-unsupported-reqs-log"]
+	if !ok || !requirementsLog.IsObject() {
+		return
+	}
+
+	ids := unsupportedRequirements(requirementsLog)
+	for _, id := range ids {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeOcpUnsupportedRequirement, prometheus.GaugeValue, 1, withLabels(labels, id)...)
+	}
+
+	count := float64(len(ids))
+	if reported, ok := logUint(requirementsLog.Get("Number Unsupported Req IDs")); ok {
+		count = float64(reported)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.nvmeOcpUnsupportedRequirements, prometheus.GaugeValue, count, labels...)
+}
+
+// complianceRow is one device of the compliance report.
+type complianceRow struct {
+	device       string
+	model        string
+	serial       string
+	firmware     string
+	ocpVersion   string
+	status       string
+	requirements []string
+}
+
+func newComplianceRow(device deviceSnapshot) complianceRow {
+	row := complianceRow{
+		device:     device.info.Get("DevicePath").String(),
+		model:      device.info.Get("ModelNumber").String(),
+		serial:     device.info.Get("SerialNumber").String(),
+		firmware:   device.info.Get("Firmware").String(),
+		ocpVersion: "-",
+		status:     "COMPLIANT",
+	}
+
+	major := device.ocpSmartLog.Get("Major Version Field")
+	if major.Exists() {
+		row.ocpVersion = major.String() + "." + device.ocpSmartLog.Get("Minor Version Field").String()
+	}
+
+	requirementsLog := device.logs["ocp-unsupported-reqs-log"]
+	if !requirementsLog.IsObject() {
+		row.status = "UNKNOWN"
+
+		return row
+	}
+
+	row.requirements = unsupportedRequirements(requirementsLog)
+	if len(row.requirements) > 0 {
+		row.status = "NON-COMPLIANT"
+	}
+
+	return row
+}
+
+func (r complianceRow) fields() []string {
+	return []string{
+		r.device, r.model, r.serial, r.firmware, r.ocpVersion, r.status,
+		strconv.Itoa(len(r.requirements)), strings.Join(r.requirements, " "),
+	}
+}
+
+var _complianceHeader = []string{
+	"DEVICE", "MODEL", "SERIAL", "FIRMWARE", "OCP_VERSION", "STATUS", "UNSUPPORTED", "REQUIREMENT_IDS",
+}
+
+func writeComplianceText(out io.Writer, rows []complianceRow) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(_complianceHeader, "\t"))
+
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row.fields(), "\t"))
+	}
+
+	return w.Flush()
+}
+
+func writeComplianceCsv(out io.Writer, rows []complianceRow) error {
+	w := csv.NewWriter(out)
+	_ = w.Write(_complianceHeader)
+
+	for _, row := range rows {
+		_ = w.Write(row.fields())
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+func runCompliance(args []string) int {
+	flags := flag.NewFlagSet("compliance", flag.ExitOnError)
+	format := flags.String("format", "text", "Output format, text or csv")
+	flags.Usage = func() {
+		fmt.Println("Usage: nvme_exporter compliance [options] [device]")
+		fmt.Println("Reports the OCP datacenter NVMe SSD specification requirements each device does not meet.")
+		fmt.Println("Exits with 1 if any device is non-compliant or its unsupported requirements log cannot be read.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	writers := map[string]func(io.Writer, []complianceRow) error{
+		"text": writeComplianceText,
+		"csv":  writeComplianceCsv,
+	}
+
+	write, ok := writers[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, must be text or csv\n", *format)
+
+		return 1
+	}
+
+	collector := newNvmeCollector(true, _defaultIdentity)
+	collector.device = devicePath(flags.Arg(0))
+	collector.logPages = []logPageCollector{newOcpRequirementsCollector(_defaultIdentity.labelNames())}
+
+	return writeCompliance(os.Stdout, collector, write)
+}
+
+// writeCompliance prints the compliance report of the devices of the
+// collector, failing if any device is non-compliant or cannot be read.
+func writeCompliance(out io.Writer, collector *nvmeCollector, write func(io.Writer, []complianceRow) error) int {
+	snap := collector.refresh()
+	if len(snap.errors) > 0 {
+		fmt.Fprintln(os.Stderr, strings.Join(snap.errors, "\n"))
+
+		return 1
+	}
+
+	if len(snap.devices) == 0 {
+		fmt.Fprintln(os.Stderr, "No NVMe devices found")
+
+		return 1
+	}
+
+	exitCode := 0
+	rows := make([]complianceRow, 0, len(snap.devices))
+
+	for _, device := range snap.devices {
+		row := newComplianceRow(device)
+		if row.status != "COMPLIANT" {
+			exitCode = 1
+		}
+
+		rows = append(rows, row)
+	}
+
+	err := write(out, rows)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %s\n", err)
+
+		return 1
+	}
+
+	return exitCode
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// testRequirementsLog is an ocp unsupported-reqs-log -o json output with two
+// space padded requirement IDs and an unused NUL entry.
+const testRequirementsLog = `{
+  "Number Unsupported Req IDs": 2,
+  "Unsupported Requirement List 0": "PLP-1           ",
+  "Unsupported Requirement List 1": "SEC-22",
+  "Unsupported Requirement List 2": "\u0000\u0000\u0000\u0000",
+  "Log Page Version": 1,
+  "Log page GUID": "0xc7bb98b7d0324863bb2c23990e9c722f"
+}`
+
+// testComplianceOutputs are the outputs of a non-compliant nvme0n1 and a
+// compliant nvme1n1.
+var testComplianceOutputs = map[string]string{
+	"list":                                  testDeviceList,
+	"smart-log":                             `{"temperature":310}`,
+	"ocp-smart-add-log":                     `{"Major Version Field":2,"Minor Version Field":5}`,
+	"ocp-unsupported-reqs-log /dev/nvme0n1": testRequirementsLog,
+	"ocp-unsupported-reqs-log /dev/nvme1n1": `{"Number Unsupported Req IDs":0}`,
+}
+
+// testComplianceCollector returns a collector of the unsupported requirements
+// running the outputs.
+func testComplianceCollector(t *testing.T, outputs map[string]string) *nvmeCollector {
+	t.Helper()
+
+	collector := testCliCollector(t, outputs)
+	collector.ocp = true
+	collector.logPages = []logPageCollector{newOcpRequirementsCollector([]string{"device"})}
+
+	return collector
+}
+
+func TestUnsupportedRequirements(t *testing.T) {
+	for log, want := range map[string][]string{
+		testRequirementsLog:                    {"PLP-1", "SEC-22"},
+		`{"Number Unsupported Req IDs":0}`:     nil,
+		`{"Unsupported Requirement List":"X"}`: {"X"},
+		`{}`:                                   nil,
+	} {
+		if got := unsupportedRequirements(gjson.Parse(log)); !reflect.DeepEqual(got, want) {
+			t.Errorf("unsupportedRequirements(%s) = %q, want %q", log, got, want)
+		}
+	}
+}
+
+func TestOcpRequirementsCollector(t *testing.T) {
+	c := newTestLogPages(newOcpRequirementsCollector([]string{"device"}),
+		map[string]string{"ocp-unsupported-reqs-log": testRequirementsLog})
+
+	expected := `
+# HELP nvme_ocp_unsupported_requirement OCP datacenter NVMe SSD specification requirement the device does not meet
+# TYPE nvme_ocp_unsupported_requirement gauge
+nvme_ocp_unsupported_requirement{device="/dev/nvme0n1",requirement_id="PLP-1"} 1
+nvme_ocp_unsupported_requirement{device="/dev/nvme0n1",requirement_id="SEC-22"} 1
+# HELP nvme_ocp_unsupported_requirements Number of OCP datacenter NVMe SSD specification requirements the device does not meet
+# TYPE nvme_ocp_unsupported_requirements gauge
+nvme_ocp_unsupported_requirements{device="/dev/nvme0n1"} 2
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteComplianceText(t *testing.T) {
+	var out bytes.Buffer
+
+	if code := writeCompliance(&out, testComplianceCollector(t, testComplianceOutputs), writeComplianceText); code != 1 {
+		t.Errorf("exit code %d with a non-compliant device, want 1", code)
+	}
+
+	want := "DEVICE        MODEL    SERIAL  FIRMWARE  OCP_VERSION  STATUS         UNSUPPORTED  REQUIREMENT_IDS\n" +
+		"/dev/nvme0n1  MODEL A  S0      FW1       2.5          NON-COMPLIANT  2            PLP-1 SEC-22\n" +
+		"/dev/nvme1n1  MODEL B  S1      FW2       2.5          COMPLIANT      0            \n"
+	if out.String() != want {
+		t.Errorf("text report:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestWriteComplianceCsv(t *testing.T) {
+	outputs := map[string]string{}
+	for query, output := range testComplianceOutputs {
+		outputs[query] = output
+	}
+
+	// nvme1n1 does not implement the log page.
+	delete(outputs, "ocp-unsupported-reqs-log /dev/nvme1n1")
+	delete(outputs, "ocp-smart-add-log")
+
+	var out bytes.Buffer
+
+	if code := writeCompliance(&out, testComplianceCollector(t, outputs), writeComplianceCsv); code != 1 {
+		t.Errorf("exit code %d with an unreadable device, want 1", code)
+	}
+
+	want := "DEVICE,MODEL,SERIAL,FIRMWARE,OCP_VERSION,STATUS,UNSUPPORTED,REQUIREMENT_IDS\n" +
+		"/dev/nvme0n1,MODEL A,S0,FW1,-,NON-COMPLIANT,2,PLP-1 SEC-22\n" +
+		"/dev/nvme1n1,MODEL B,S1,FW2,-,UNKNOWN,0,\n"
+	if out.String() != want {
+		t.Errorf("csv report:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestWriteComplianceExitCode(t *testing.T) {
+	compliant := map[string]string{
+		"list":                     testDeviceList,
+		"smart-log":                `{"temperature":310}`,
+		"ocp-unsupported-reqs-log": `{"Number Unsupported Req IDs":0}`,
+	}
+
+	for name, test := range map[string]struct {
+		outputs map[string]string
+		device  string
+		want    int
+	}{
+		"all compliant":       {compliant, "", 0},
+		"compliant device":    {testComplianceOutputs, "/dev/nvme1n1", 0},
+		"noncompliant device": {testComplianceOutputs, "/dev/nvme0n1", 1},
+		"unknown device":      {testComplianceOutputs, "/dev/nvme7n1", 1},
+		"list failing":        {nil, "", 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			collector := testComplianceCollector(t, test.outputs)
+			collector.device = test.device
+
+			var out bytes.Buffer
+			if code := writeCompliance(&out, collector, writeComplianceCsv); code != test.want {
+				t.Errorf("exit code %d, want %d", code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// _aerSeverities maps the sysfs AER statistics files to the severity label.
+var _aerSeverities = map[string]string{
+	"aer_dev_correctable": "correctable",
+	"aer_dev_nonfatal":    "nonfatal",
+	"aer_dev_fatal":       "fatal",
+}
+
+// pcieCollector exports the link status and AER counters of the PCI device
+// behind each NVMe controller. Fabrics controllers have none and are skipped.
+type pcieCollector struct {
+	identity             identityConfig
+	nvmePcieLinkSpeed    *prometheus.Desc
+	nvmePcieLinkMaxSpeed *prometheus.Desc
+	nvmePcieLinkWidth    *prometheus.Desc
+	nvmePcieLinkMaxWidth *prometheus.Desc
+	nvmePcieAerErrors    *prometheus.Desc
+}
+
+func newPcieCollector(identity identityConfig) *pcieCollector {
+	labels := withLabels(identity.labelNames(), "address")
+
+	return &pcieCollector{
+		identity: identity,
+		nvmePcieLinkSpeed: prometheus.NewDesc(
+			"nvme_pcie_link_speed_gts",
+			"Current PCIe link speed in GT/s",
+			labels,
+			nil,
+		),
+		nvmePcieLinkMaxSpeed: prometheus.NewDesc(
+			"nvme_pcie_link_max_speed_gts",
+			"Maximum PCIe link speed supported in GT/s",
+			labels,
+			nil,
+		),
+		nvmePcieLinkWidth: prometheus.NewDesc(
+			"nvme_pcie_link_width",
+			"Current number of PCIe lanes",
+			labels,
+			nil,
+		),
+		nvmePcieLinkMaxWidth: prometheus.NewDesc(
+			"nvme_pcie_link_max_width",
+			"Maximum number of PCIe lanes supported",
+			labels,
+			nil,
+		),
+		nvmePcieAerErrors: prometheus.NewDesc(
+			"nvme_pcie_aer_errors_total",
+			"PCIe Advanced Error Reporting errors by severity and type",
+			withLabels(labels, "severity", "type"),
+			nil,
+		),
+	}
+}
+
+func (c *pcieCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmePcieLinkSpeed
+	ch <- c.nvmePcieLinkMaxSpeed
+	ch <- c.nvmePcieLinkWidth
+	ch <- c.nvmePcieLinkMaxWidth
+	ch <- c.nvmePcieAerErrors
+}
+
+// parseLinkSpeed parses link speeds such as "16.0 GT/s PCIe".
+func parseLinkSpeed(speed string) (float64, bool) {
+	value, _, _ := strings.Cut(speed, " ")
+	gts, err := strconv.ParseFloat(value, 64)
+
+	return gts, err == nil
+}
+
+func (c *pcieCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, controller := range controllerDirs(c.identity.sysRoot) {
+		if readSysfs(filepath.Join(controller, "transport")) != "pcie" {
+			continue
+		}
+
+		pciDevice, err := filepath.EvalSymlinks(filepath.Join(controller, "device"))
+		if err != nil {
+			continue
+		}
+
+		labels := withLabels(c.identity.controllerLabels(filepath.Base(controller)), filepath.Base(pciDevice))
+
+		if speed, ok := parseLinkSpeed(readSysfs(filepath.Join(pciDevice, "current_link_speed"))); ok {
+			ch <- prometheus.MustNewConstMetric(c.nvmePcieLinkSpeed, prometheus.GaugeValue, speed, labels...)
+		}
+
+		if speed, ok := parseLinkSpeed(readSysfs(filepath.Join(pciDevice, "max_link_speed"))); ok {
+			ch <- prometheus.MustNewConstMetric(c.nvmePcieLinkMaxSpeed, prometheus.GaugeValue, speed, labels...)
+		}
+
+		if width, ok := readSysfsFloat(filepath.Join(pciDevice, "current_link_width")); ok {
+			ch <- prometheus.MustNewConstMetric(c.nvmePcieLinkWidth, prometheus.GaugeValue, width, labels...)
+		}
+
+		if width, ok := readSysfsFloat(filepath.Join(pciDevice, "max_link_width")); ok {
+			ch <- prometheus.MustNewConstMetric(c.nvmePcieLinkMaxWidth, prometheus.GaugeValue, width, labels...)
+		}
+
+		for file, severity := range _aerSeverities {
+			c.sendAerErrors(ch, filepath.Join(pciDevice, file), labels, severity)
+		}
+	}
+}
+
+// sendAerErrors exports an AER statistics file made of "<type> <count>"
+// lines. The TOTAL_ERR_* lines are skipped as they sum the others.
+func (c *pcieCollector) sendAerErrors(ch chan<- prometheus.Metric, path string, labels []string, severity string) {
+	aer, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return
+	}
+	defer aer.Close()
+
+	scanner := bufio.NewScanner(aer)
+	for scanner.Scan() {
+		errType, value, found := strings.Cut(scanner.Text(), " ")
+		if !found || strings.HasPrefix(errType, "TOTAL_") {
+			continue
+		}
+
+		count, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmePcieAerErrors, prometheus.CounterValue, count, withLabels(labels, severity, errType)...)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+// eventTimestampMask keeps the milliseconds of an NVMe timestamp, the upper
+// bits hold its origin and synchronization attributes.
+const eventTimestampMask = 1<<48 - 1
+
+// _persistentEventTypes maps the persistent event types, as printed by
+// nvme-cli or as raw event type codes, to the type label.
+var _persistentEventTypes = map[string]string{
+	"SMART/Health Log Snapshot Event":    "smart_health_snapshot",
+	"Firmware Commit Event":              "firmware_commit",
+	"Timestamp Change Event":             "timestamp_change",
+	"Power-on or Reset Event":            "power_on_reset",
+	"NVM Subsystem Hardware Error Event": "hardware_error",
+	"Change Namespace Event":             "change_namespace",
+	"Format NVM Start Event":             "format_start",
+	"Format NVM Completion Event":        "format_completion",
+	"Sanitize Start Event":               "sanitize_start",
+	"Sanitize Completion Event":          "sanitize_completion",
+	"Set Feature Event":                  "set_feature",
+	"Telemetry Log Create Event":         "telemetry_log_create",
+	"Thermal Excursion Event":            "thermal_excursion",
+	"Vendor Specific Event":              "vendor_specific",
+	"TCG Defined Event":                  "tcg_defined",
+	"1":                                  "smart_health_snapshot",
+	"2":                                  "firmware_commit",
+	"3":                                  "timestamp_change",
+	"4":                                  "power_on_reset",
+	"5":                                  "hardware_error",
+	"6":                                  "change_namespace",
+	"7":                                  "format_start",
+	"8":                                  "format_completion",
+	"9":                                  "sanitize_start",
+	"10":                                 "sanitize_completion",
+	"11":                                 "set_feature",
+	"12":                                 "telemetry_log_create",
+	"13":                                 "thermal_excursion",
+	"222":                                "vendor_specific",
+	"223":                                "tcg_defined",
+}
+
+// _eventTypeCleanRe matches the characters replaced in unknown event types.
+var _eventTypeCleanRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+func persistentEventType(event gjson.Result) string {
+	eventType := event.Get("event_type").String()
+	if name, ok := _persistentEventTypes[eventType]; ok {
+		return name
+	}
+
+	return strings.Trim(_eventTypeCleanRe.ReplaceAllString(strings.ToLower(eventType), "_"), "_")
+}
+
+// persistentEventID identifies an event in the log. The log has no sequence
+// numbers, its entries are only ordered, so the last seen event is found again
+// by its content.
+func persistentEventID(event gjson.Result) string {
+	return fmt.Sprintf("%s/%s/%s/%s", event.Get("event_type").String(), event.Get("event_time_stamp").String(),
+		event.Get("ctrl_id").String(), event.Get("event_len").String())
+}
+
+// persistentEventCount is the number of events of one type seen so far and
+// the time stamp of the latest, in seconds since the epoch.
+type persistentEventCount struct {
+	Count         float64 `json:"count"`
+	LastTimestamp float64 `json:"last_timestamp"`
+}
+
+// persistentEventState is what was read from the persistent event log of a
+// controller so far: the log generation number, the last seen event and its
+// time stamp in milliseconds.
+type persistentEventState struct {
+	Generation    string                          `json:"generation"`
+	LastEvent     string                          `json:"last_event"`
+	LastTimestamp uint64                          `json:"last_event_timestamp"`
+	Events        map[string]persistentEventCount `json:"events"`
+}
+
+// update counts the events following the last seen one. A new generation
+// number means the log was cleared and all its events are new. When the last
+// seen event is no longer in the log, as it wrapped and discarded it, the
+// events not newer than it were already counted. It reports whether the state
+// changed.
+func (s *persistentEventState) update(generation string, events []gjson.Result) bool {
+	if s.Events == nil {
+		s.Events = map[string]persistentEventCount{}
+	}
+
+	changed := generation != s.Generation
+	if changed && s.Generation != "" {
+		s.LastEvent = ""
+		s.LastTimestamp = 0
+	}
+
+	s.Generation = generation
+
+	start := 0
+	found := false
+
+	for i := len(events) - 1; i >= 0 && s.LastEvent != ""; i-- {
+		if persistentEventID(events[i]) == s.LastEvent {
+			start = i + 1
+			found = true
+
+			break
+		}
+	}
+
+	for _, event := range events[start:] {
+		timestamp := event.Get("event_time_stamp").Uint() & eventTimestampMask
+		if !found && s.LastEvent != "" && timestamp <= s.LastTimestamp {
+			continue
+		}
+
+		eventType := persistentEventType(event)
+		count := s.Events[eventType]
+		count.Count++
+		count.LastTimestamp = float64(timestamp) / millisecondsPerSec
+		s.Events[eventType] = count
+		s.LastEvent = persistentEventID(event)
+		s.LastTimestamp = timestamp
+		changed = true
+	}
+
+	return changed
+}
+
+// persistentEventsCollector counts the events of the persistent event log.
+// The log is read in full on every collection and its reporting context
+// released afterwards, the last seen event of every controller is kept in a
+// state file so restarts do not count events again.
+type persistentEventsCollector struct {
+	mu                        sync.Mutex
+	stateFile                 string
+	state                     map[string]*persistentEventState
+	nvmePersistentEvents      *prometheus.Desc
+	nvmePersistentEventLastTs *prometheus.Desc
+}
+
+func newPersistentEventsCollector(labels []string, stateFile string) (*persistentEventsCollector, error) {
+	c := &persistentEventsCollector{
+		stateFile: stateFile,
+		state:     map[string]*persistentEventState{},
+		nvmePersistentEvents: prometheus.NewDesc(
+			"nvme_persistent_events_total",
+			"Number of events recorded in the persistent event log, by event type",
+			withLabels(labels, "type"),
+			nil,
+		),
+		nvmePersistentEventLastTs: prometheus.NewDesc(
+			"nvme_persistent_event_last_timestamp_seconds",
+			"Device time stamp of the latest event of the type, in seconds since the epoch",
+			withLabels(labels, "type"),
+			nil,
+		),
+	}
+
+	data, err := os.ReadFile(filepath.Clean(stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading persistent event state: %w", err)
+	}
+
+	err = json.Unmarshal(data, &c.state)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing persistent event state %s: %w", stateFile, err)
+	}
+
+	return c, nil
+}
+
+// save writes the state file atomically, so a crash never leaves it partial.
+func (c *persistentEventsCollector) save() error {
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return fmt.Errorf("error encoding persistent event state: %w", err)
+	}
+
+	temp := c.stateFile + ".tmp"
+
+	err = os.WriteFile(temp, data, 0o600)
+	if err != nil {
+		return fmt.Errorf("error writing persistent event state: %w", err)
+	}
+
+	err = os.Rename(temp, c.stateFile)
+	if err != nil {
+		return fmt.Errorf("error writing persistent event state: %w", err)
+	}
+
+	return nil
+}
+
+// queries reads the log, then releases the reporting context it established.
+func (c *persistentEventsCollector) queries(device deviceSnapshot) []string {
+	if _, ok := device.logs["persistent-event-log"]; !ok {
+		return []string{"persistent-event-log"}
+	}
+
+	return []string{"persistent-event-log", "persistent-event-release"}
+}
+
+// update counts the new events of the controller log and saves the state. It
+// runs once per collected device, send only reports the counts.
+func (c *persistentEventsCollector) update(device deviceSnapshot) {
+	eventLog := device.logs["persistent-event-log"]
+	if !eventLog.IsObject() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The log belongs to the controller, namespaces sharing it share the state.
+	serial := device.info.Get("SerialNumber").String()
+
+	state := c.state[serial]
+	if state == nil {
+		state = &persistentEventState{}
+		c.state[serial] = state
+	}
+
+	if state.update(eventLog.Get("gen_number").String(), eventLog.Get("list_of_event_entries").Array()) {
+		err := c.save()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (c *persistentEventsCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmePersistentEvents
+	ch <- c.nvmePersistentEventLastTs
+}
+
+func (c *persistentEventsCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.state[device.info.Get("SerialNumber").String()]
+	if state == nil {
+		return
+	}
+
+	for eventType, count := range state.Events {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmePersistentEvents, prometheus.CounterValue, count.Count, withLabels(labels, eventType)...)
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmePersistentEventLastTs, prometheus.GaugeValue, count.LastTimestamp, withLabels(labels, eventType)...)
+	}
+}
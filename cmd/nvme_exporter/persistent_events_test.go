@@ -0,0 +1,151 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// testPersistentEventLog returns a persistent-event-log -o json output holding
+// the events, given as event type and time stamp pairs.
+func testPersistentEventLog(events ...string) string {
+	entries := make([]string, 0, len(events)/2)
+	for i := 0; i+1 < len(events); i += 2 {
+		entries = append(entries, `{"event_type":`+events[i]+`,"event_time_stamp":`+events[i+1]+
+			`,"ctrl_id":1,"event_len":20}`)
+	}
+
+	return `{"list_of_event_entries":[` + strings.Join(entries, ",") + `]}`
+}
+
+// testPersistentEvents returns a collector whose queries return the event log
+// output, and the queries it ran.
+func testPersistentEvents(t *testing.T, eventLog *string) (*nvmeCollector, *persistentEventsCollector, *[]string) {
+	t.Helper()
+
+	events, err := newPersistentEventsCollector([]string{"device"}, filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ran []string
+
+	collector := newNvmeCollector(false, testIdentity(t.TempDir()))
+	collector.logPages = []logPageCollector{events}
+	collector.run = func(query, _ string) ([]byte, error) {
+		ran = append(ran, query)
+		if query == "persistent-event-log" {
+			return []byte(*eventLog), nil
+		}
+
+		return []byte("{}"), nil
+	}
+
+	return collector, events, &ran
+}
+
+func TestPersistentEventsCollector(t *testing.T) {
+	eventLog := testPersistentEventLog("2", "1792317600000", "4", "1792317601000")
+	collector, events, ran := testPersistentEvents(t, &eventLog)
+	nvmeDevice := gjson.Parse(`{"DevicePath":"/dev/nvme0n1","SerialNumber":"S1"}`)
+
+	c := testLogPages{logPageCollector: events, device: collector.collectDevice(nvmeDevice, time.Now())}
+
+	want := []string{"smart-log", "persistent-event-log", "persistent-event-release"}
+	if !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran %v, want %v", *ran, want)
+	}
+
+	expected := `
+# HELP nvme_persistent_events_total Number of events recorded in the persistent event log, by event type
+# TYPE nvme_persistent_events_total counter
+nvme_persistent_events_total{device="/dev/nvme0n1",type="firmware_commit"} 1
+nvme_persistent_events_total{device="/dev/nvme0n1",type="power_on_reset"} 1
+`
+
+	// Sending the same snapshot again, as the JSON API does, counts nothing.
+	for range 2 {
+		err := testutil.CollectAndCompare(c, strings.NewReader(expected), "nvme_persistent_events_total")
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	eventLog = testPersistentEventLog("2", "1792317600000", "4", "1792317601000", "4", "1792317605000")
+	c.device = collector.collectDevice(nvmeDevice, time.Now())
+
+	expected = `
+# HELP nvme_persistent_events_total Number of events recorded in the persistent event log, by event type
+# TYPE nvme_persistent_events_total counter
+nvme_persistent_events_total{device="/dev/nvme0n1",type="firmware_commit"} 1
+nvme_persistent_events_total{device="/dev/nvme0n1",type="power_on_reset"} 2
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "nvme_persistent_events_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// A restart loads the saved state and counts only the events after it.
+	restarted, err := newPersistentEventsCollector([]string{"device"}, events.stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted.update(c.device)
+
+	if got := restarted.state["S1"].Events["power_on_reset"].Count; got != 2 {
+		t.Errorf("power_on_reset count after a restart = %v, want 2", got)
+	}
+}
+
+func TestPersistentEventStateWrapped(t *testing.T) {
+	state := persistentEventState{Generation: "1", LastEvent: "4/1792317600000/1/20", LastTimestamp: 1792317600000}
+	events := gjson.Parse(testPersistentEventLog("13", "1792317500000", "13", "1792317700000", "13", "1792317800000")).
+		Get("list_of_event_entries").Array()
+
+	// The last seen event was discarded, the older event left was counted
+	// before it.
+	if !state.update("1", events) {
+		t.Fatal("update counted no events")
+	}
+
+	want := persistentEventCount{Count: 2, LastTimestamp: 1792317800}
+	if got := state.Events["thermal_excursion"]; got != want {
+		t.Errorf("thermal_excursion = %+v, want %+v", got, want)
+	}
+
+	if state.update("1", events) {
+		t.Error("update counted the same events again")
+	}
+
+	// A new generation is a cleared log, all its events are new.
+	if !state.update("2", events[:1]) {
+		t.Fatal("update counted no events of the new generation")
+	}
+
+	want = persistentEventCount{Count: 3, LastTimestamp: 1792317500}
+	if got := state.Events["thermal_excursion"]; got != want {
+		t.Errorf("thermal_excursion after the log was cleared = %+v, want %+v", got, want)
+	}
+}
+
+func TestPersistentEventStateFirstGeneration(t *testing.T) {
+	// A state saved before generation numbers were tracked keeps its last event.
+	state := persistentEventState{LastEvent: "13/1792317700000/1/20", LastTimestamp: 1792317700000}
+	events := gjson.Parse(testPersistentEventLog("13", "1792317600000", "13", "1792317700000")).
+		Get("list_of_event_entries").Array()
+
+	if !state.update("7", events) || state.Generation != "7" {
+		t.Fatalf("generation %q, want 7", state.Generation)
+	}
+
+	if got := state.Events["thermal_excursion"].Count; got != 0 {
+		t.Errorf("thermal_excursion count = %v, want 0", got)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// capSysAdmin is the capability the kernel requires for NVMe admin commands
+// such as get-log-page, see linux/capability.h.
+const capSysAdmin = 21
+
+// _deviceGlob matches the NVMe controller and namespace nodes nvme-cli opens.
+const _deviceGlob = "/dev/nvme[0-9]*"
+
+var errMissingCapSysAdmin = errors.New("missing CAP_SYS_ADMIN capability, required for NVMe admin commands")
+
+// effectiveCapabilities returns the effective capability set of the process.
+func effectiveCapabilities() (uint64, error) {
+	status, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, fmt.Errorf("error reading process capabilities: %w", err)
+	}
+	defer status.Close()
+
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if found {
+			capabilities, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+			if err != nil {
+				return 0, fmt.Errorf("error parsing process capabilities: %w", err)
+			}
+
+			return capabilities, nil
+		}
+	}
+
+	return 0, errors.New("no CapEff entry in /proc/self/status")
+}
+
+// checkPrivileges verifies the exporter can issue the NVMe admin commands
+// nvme-cli needs: it must hold CAP_SYS_ADMIN and be able to open every NVMe
+// device node. This works for root, for a dedicated user with ambient
+// capabilities and inside user-namespaced containers alike.
+func checkPrivileges() error {
+	capabilities, err := effectiveCapabilities()
+	if err != nil {
+		return err
+	}
+
+	if capabilities&(1<<capSysAdmin) == 0 {
+		return errMissingCapSysAdmin
+	}
+
+	devices, err := filepath.Glob(_deviceGlob)
+	if err != nil {
+		return fmt.Errorf("error listing NVMe devices: %w", err)
+	}
+
+	for _, device := range devices {
+		file, err := os.Open(device)
+		if err != nil {
+			return fmt.Errorf("cannot open NVMe device, grant read access or CAP_DAC_READ_SEARCH: %w", err)
+		}
+
+		_ = file.Close()
+	}
+
+	return nil
+}
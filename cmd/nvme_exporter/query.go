@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// queryRunner runs one of the named nvme-cli queries against a device.
+type queryRunner func(query, device string) ([]byte, error)
+
+// _queries are the read-only nvme-cli invocations the collector issues, by
+// name. They are also the only commands the privileged helper accepts.
+var _queries = map[string]func(device string) []string{
+	"list": func(string) []string {
+		return []string{"list", "-o", "json"}
+	},
+	"smart-log": func(device string) []string {
+		return []string{"smart-log", device, "-o", "json"}
+	},
+	"ocp-smart-add-log": func(device string) []string {
+		return []string{"ocp", "smart-add-log", device, "-o", "json"}
+	},
+	"ocp-latency-monitor-log": func(device string) []string {
+		return []string{"ocp", "latency-monitor-log", device, "-o", "json"}
+	},
+	"ocp-error-recovery-log": func(device string) []string {
+		return []string{"ocp", "error-recovery-log", device, "-o", "json"}
+	},
+	"ocp-device-capability-log": func(device string) []string {
+		return []string{"ocp", "device-capability-log", device, "-o", "json"}
+	},
+	"ocp-unsupported-reqs-log": func(device string) []string {
+		return []string{"ocp", "unsupported-reqs-log", device, "-o", "json"}
+	},
+	"id-ctrl": func(device string) []string {
+		return []string{"id-ctrl", device, "-o", "json"}
+	},
+	"id-ns": func(device string) []string {
+		return []string{"id-ns", device, "-o", "json"}
+	},
+	"zns-id-ns": func(device string) []string {
+		return []string{"zns", "id-ns", device, "-o", "json"}
+	},
+	"endurance-group-list": func(device string) []string {
+		return []string{"id-endurance-grp-list", device, "-o", "json"}
+	},
+	"sanitize-log": func(device string) []string {
+		return []string{"sanitize-log", device, "-o", "json"}
+	},
+	// Action 1 establishes a new reporting context, the log cannot be read
+	// without one. This does not modify the log.
+	"persistent-event-log": func(device string) []string {
+		return []string{"persistent-event-log", device, "--action=1", "-o", "json"}
+	},
+	// Action 2 releases the reporting context once the log was read, so the
+	// controller does not keep it, and the events it holds back, until the
+	// next collection.
+	"persistent-event-release": func(device string) []string {
+		return []string{"persistent-event-log", device, "--action=2"}
+	},
+	// The header of the controller-initiated telemetry log, which nvme-cli
+	// cannot print as JSON.
+	"telemetry-ctrl-header": func(device string) []string {
+		return []string{"get-log", device, "--log-id=8", "--log-len=512", "--raw-binary"}
+	},
+	// The header of the host-initiated telemetry log. Without the create bit
+	// it returns the data of the last capture and does not create new data.
+	"telemetry-host-header": func(device string) []string {
+		return []string{"get-log", device, "--log-id=7", "--log-len=512", "--raw-binary"}
+	},
+	// The APST feature data structure, get-feature only prints it as binary or
+	// as a hex dump.
+	"apst-table": func(device string) []string {
+		return []string{"get-feature", device, "--feature-id", "12", "--raw-binary"}
+	},
+}
+
+// _binaryQueries print raw data or text instead of JSON, by query name
+// without the number of _numberQueries.
+var _binaryQueries = map[string]bool{
+	"persistent-event-release": true,
+	"telemetry-ctrl-header":    true,
+	"telemetry-host-header":    true,
+	"apst-table":               true,
+	"feature":                  true,
+	"temperature-threshold":    true,
+}
+
+// isBinaryQuery reports whether the output of a query is stored raw.
+func isBinaryQuery(query string) bool {
+	name, _, _ := strings.Cut(query, ":")
+
+	return _binaryQueries[name]
+}
+
+// _numberQueries take a numeric parameter, such as an endurance group ID, and
+// are named "<query>:<number>".
+var _numberQueries = map[string]func(device, number string) []string{
+	"endurance-log": func(device, group string) []string {
+		return []string{"endurance-log", device, "--group-id", group, "-o", "json"}
+	},
+	"feature": func(device, feature string) []string {
+		return []string{"get-feature", device, "--feature-id", feature}
+	},
+	// The temperature threshold feature (4), for the sensor and threshold
+	// type selected in CDW11.
+	"temperature-threshold": func(device, selection string) []string {
+		return []string{"get-feature", device, "--feature-id", "4", "--cdw11", selection}
+	},
+	// A single zone descriptor, the report header counts all zones in the
+	// state.
+	"zns-report-zones": func(device, state string) []string {
+		return []string{"zns", "report-zones", device, "--descs", "1", "--state", state, "-o", "json"}
+	},
+}
+
+// _numberRe matches the parameter of _numberQueries.
+var _numberRe = regexp.MustCompile(`^\d{1,7}$`)
+
+// _devicePathRe matches NVMe controller and namespace device paths.
+var _devicePathRe = regexp.MustCompile(`^/dev/nvme\d+(n\d+)?$`)
+
+// queryArgs validates a query and returns the nvme-cli arguments for it.
+func queryArgs(query, device string) ([]string, error) {
+	if query != "list" && !_devicePathRe.MatchString(device) {
+		return nil, fmt.Errorf("invalid device %q for query %s", device, query)
+	}
+
+	name, number, found := strings.Cut(query, ":")
+	if found {
+		args, ok := _numberQueries[name]
+		if !ok || !_numberRe.MatchString(number) {
+			return nil, fmt.Errorf("unknown query %q", query)
+		}
+
+		return args(device, number), nil
+	}
+
+	args, ok := _queries[query]
+	if !ok {
+		return nil, fmt.Errorf("unknown query %q", query)
+	}
+
+	return args(device), nil
+}
+
+// runLocalQuery runs a query with nvme-cli in this process.
+func runLocalQuery(query, device string) ([]byte, error) {
+	args, err := queryArgs(query, device)
+	if err != nil {
+		return nil, err
+	}
+
+	if isBinaryQuery(query) {
+		return executeBinaryCommand("nvme", args...)
+	}
+
+	return executeCommand("nvme", args...)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// sanitizeInProgress is the Sanitize Status log SSTAT status of a running
+	// sanitize operation.
+	sanitizeInProgress = 2
+	// sanitizeProgressScale is the SPROG denominator of the completed fraction.
+	sanitizeProgressScale = 65536
+	// sanitizeNoEstimate is reported for methods without a time estimate.
+	sanitizeNoEstimate = 0xffffffff
+	// formatProgressSupported is the id-ns FPI bit set by namespaces
+	// reporting the remaining part of a running format, in the low bits.
+	formatProgressSupported = 1 << 7
+	formatProgressMask      = formatProgressSupported - 1
+)
+
+// _sanitizeEstimates maps the Sanitize Status log estimated times, the total
+// duration of an operation printed by nvme-cli in seconds, to the method and
+// no_deallocate labels.
+var _sanitizeEstimates = []struct {
+	key          string
+	method       string
+	noDeallocate string
+}{
+	{"time_over_write", "overwrite", "false"},
+	{"time_block_erase", "block_erase", "false"},
+	{"time_crypto_erase", "crypto_erase", "false"},
+	{"time_over_write_no_dealloc", "overwrite", "true"},
+	{"time_block_erase_no_dealloc", "block_erase", "true"},
+	{"time_crypto_erase_no_dealloc", "crypto_erase", "true"},
+}
+
+// _sanitizeStatusRe matches the status code nvme-cli prints before the status
+// description, such as "(2) Sanitize in Progress.".
+var _sanitizeStatusRe = regexp.MustCompile(`^\((\d+)\)`)
+
+// sanitizeLog returns the Sanitize Status log of the device. nvme-cli nests it
+// under the device name.
+func sanitizeLog(device deviceSnapshot) gjson.Result {
+	var sanitize gjson.Result
+
+	device.logs["sanitize-log"].ForEach(func(_, value gjson.Result) bool {
+		sanitize = value
+
+		return false
+	})
+
+	return sanitize
+}
+
+// sanitizeStatus returns the status of the most recent sanitize operation.
+func sanitizeStatus(sanitize gjson.Result) (uint64, bool) {
+	status := sanitize.Get("sstat.status")
+	if match := _sanitizeStatusRe.FindStringSubmatch(status.String()); match != nil {
+		value, err := strconv.ParseUint(match[1], 10, 64)
+
+		return value, err == nil
+	}
+
+	return logUint(status)
+}
+
+// sanitizing reports whether a sanitize operation is running on the device.
+// Most admin commands fail until it completes, so collectDevice skips them.
+func sanitizing(device deviceSnapshot) bool {
+	status, ok := sanitizeStatus(sanitizeLog(device))
+
+	return ok && status == sanitizeInProgress
+}
+
+// sanitizeCollector exports the Sanitize Status log and the format progress
+// of the namespace, to follow decommissioning.
+type sanitizeCollector struct {
+	nvmeSanitizeInProgress      *prometheus.Desc
+	nvmeSanitizeProgress        *prometheus.Desc
+	nvmeSanitizeStatus          *prometheus.Desc
+	nvmeSanitizeCompletedPasses *prometheus.Desc
+	nvmeSanitizeGlobalErased    *prometheus.Desc
+	nvmeSanitizeDuration        *prometheus.Desc
+	nvmeSanitizeRemaining       *prometheus.Desc
+	nvmeFormatRemaining         *prometheus.Desc
+}
+
+func newSanitizeCollector(labels []string) *sanitizeCollector {
+	return &sanitizeCollector{
+		nvmeSanitizeInProgress: prometheus.NewDesc(
+			"nvme_sanitize_in_progress",
+			"Whether a sanitize operation is running, other log pages are not collected meanwhile",
+			labels,
+			nil,
+		),
+		nvmeSanitizeProgress: prometheus.NewDesc(
+			"nvme_sanitize_progress_percent",
+			"Completed percentage of the running sanitize operation, 100 when none is running",
+			labels,
+			nil,
+		),
+		nvmeSanitizeStatus: prometheus.NewDesc(
+			"nvme_sanitize_status",
+			"Status of the most recent sanitize operation: 0 never sanitized, 1 completed, 2 in progress, "+
+				"3 failed, 4 completed without deallocation",
+			labels,
+			nil,
+		),
+		nvmeSanitizeCompletedPasses: prometheus.NewDesc(
+			"nvme_sanitize_completed_passes",
+			"Number of completed passes of the running or most recent overwrite sanitize operation",
+			labels,
+			nil,
+		),
+		nvmeSanitizeGlobalErased: prometheus.NewDesc(
+			"nvme_sanitize_global_data_erased",
+			"Whether no user data was written since the last sanitize or manufacture",
+			labels,
+			nil,
+		),
+		nvmeSanitizeDuration: prometheus.NewDesc(
+			"nvme_sanitize_estimated_duration_seconds",
+			"Estimated total duration of a sanitize operation with the method",
+			withLabels(labels, "method", "no_deallocate"),
+			nil,
+		),
+		nvmeSanitizeRemaining: prometheus.NewDesc(
+			"nvme_sanitize_estimated_remaining_seconds",
+			"Estimated time remaining of the running sanitize operation, from its progress, if it uses the method",
+			withLabels(labels, "method", "no_deallocate"),
+			nil,
+		),
+		nvmeFormatRemaining: prometheus.NewDesc(
+			"nvme_format_remaining_percent",
+			"Percentage of the namespace remaining to be formatted, 0 when no format is running",
+			labels,
+			nil,
+		),
+	}
+}
+
+func (c *sanitizeCollector) queries(deviceSnapshot) []string {
+	return []string{"sanitize-log", "id-ns"}
+}
+
+func (c *sanitizeCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeSanitizeInProgress
+	ch <- c.nvmeSanitizeProgress
+	ch <- c.nvmeSanitizeStatus
+	ch <- c.nvmeSanitizeCompletedPasses
+	ch <- c.nvmeSanitizeGlobalErased
+	ch <- c.nvmeSanitizeDuration
+	ch <- c.nvmeSanitizeRemaining
+	ch <- c.nvmeFormatRemaining
+}
+
+func (c *sanitizeCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	c.sendFormatProgress(ch, device, labels)
+
+	sanitize := sanitizeLog(device)
+
+	status, ok := sanitizeStatus(sanitize)
+	if !ok {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.nvmeSanitizeStatus, prometheus.GaugeValue, float64(status), labels...)
+
+	inProgress := 0.0
+	progress, ok := logUint(sanitize.Get("sprog"))
+
+	if status == sanitizeInProgress {
+		inProgress = 1
+	} else {
+		progress, ok = sanitizeProgressScale, true
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.nvmeSanitizeInProgress, prometheus.GaugeValue, inProgress, labels...)
+
+	if ok {
+		ch <- prometheus.MustNewConstMetric(c.nvmeSanitizeProgress, prometheus.GaugeValue,
+			float64(progress)*100/sanitizeProgressScale, labels...)
+	}
+
+	if passes, ok := logUint(sanitize.Get("sstat.no_cmplted_passes")); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeSanitizeCompletedPasses, prometheus.GaugeValue, float64(passes), labels...)
+	}
+
+	if erased, ok := logUint(sanitize.Get("sstat.global_erased")); ok {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeSanitizeGlobalErased, prometheus.GaugeValue, float64(erased), labels...)
+	}
+
+	c.sendEstimates(ch, sanitize, inProgress == 1 && ok, float64(progress)/sanitizeProgressScale, labels)
+}
+
+// sendEstimates exports the estimated duration of every method. The log does
+// not tell which method the running operation uses, so the time remaining is
+// exported for all of them while one runs.
+func (c *sanitizeCollector) sendEstimates(
+	ch chan<- prometheus.Metric, sanitize gjson.Result, inProgress bool, completed float64, labels []string,
+) {
+	for _, estimate := range _sanitizeEstimates {
+		seconds, ok := logUint(sanitize.Get(estimate.key))
+		if !ok || seconds == sanitizeNoEstimate {
+			continue
+		}
+
+		estimateLabels := withLabels(labels, estimate.method, estimate.noDeallocate)
+		ch <- prometheus.MustNewConstMetric(c.nvmeSanitizeDuration, prometheus.GaugeValue, float64(seconds),
+			estimateLabels...)
+
+		if inProgress {
+			ch <- prometheus.MustNewConstMetric(c.nvmeSanitizeRemaining, prometheus.GaugeValue,
+				float64(seconds)*(1-completed), estimateLabels...)
+		}
+	}
+}
+
+func (c *sanitizeCollector) sendFormatProgress(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	fpi, ok := logUint(device.logs["id-ns"].Get("fpi"))
+	if !ok || fpi&formatProgressSupported == 0 {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.nvmeFormatRemaining, prometheus.GaugeValue, float64(fpi&formatProgressMask), labels...)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testSanitizeLog is the sanitize-log -o json output of a drive a quarter of
+// the way through a sanitize operation.
+const testSanitizeLog = `{
+  "nvme0":{
+    "sprog":16384,
+    "sstat":{"status":"(2) Sanitize in Progress.","no_cmplted_passes":0,"global_erased":0},
+    "cdw10_info":0,
+    "time_over_write":4000,
+    "time_block_erase":400,
+    "time_crypto_erase":4294967295,
+    "time_over_write_no_dealloc":4294967295,
+    "time_block_erase_no_dealloc":4294967295,
+    "time_crypto_erase_no_dealloc":4294967295
+  }
+}`
+
+func TestSanitizeEstimates(t *testing.T) {
+	c := newTestLogPages(newSanitizeCollector([]string{"device"}), map[string]string{"sanitize-log": testSanitizeLog})
+
+	expected := `
+# HELP nvme_sanitize_estimated_duration_seconds Estimated total duration of a sanitize operation with the method
+# TYPE nvme_sanitize_estimated_duration_seconds gauge
+nvme_sanitize_estimated_duration_seconds{device="/dev/nvme0n1",method="block_erase",no_deallocate="false"} 400
+nvme_sanitize_estimated_duration_seconds{device="/dev/nvme0n1",method="overwrite",no_deallocate="false"} 4000
+# HELP nvme_sanitize_estimated_remaining_seconds Estimated time remaining of the running sanitize operation, from its progress, if it uses the method
+# TYPE nvme_sanitize_estimated_remaining_seconds gauge
+nvme_sanitize_estimated_remaining_seconds{device="/dev/nvme0n1",method="block_erase",no_deallocate="false"} 300
+nvme_sanitize_estimated_remaining_seconds{device="/dev/nvme0n1",method="overwrite",no_deallocate="false"} 3000
+# HELP nvme_sanitize_progress_percent Completed percentage of the running sanitize operation, 100 when none is running
+# TYPE nvme_sanitize_progress_percent gauge
+nvme_sanitize_progress_percent{device="/dev/nvme0n1"} 25
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "nvme_sanitize_estimated_duration_seconds",
+		"nvme_sanitize_estimated_remaining_seconds", "nvme_sanitize_progress_percent")
+	if err != nil {
+		t.Error(err)
+	}
+
+	completed := strings.Replace(testSanitizeLog, "(2) Sanitize in Progress.", "(1) Sanitize Completed.", 1)
+	c = newTestLogPages(newSanitizeCollector([]string{"device"}), map[string]string{"sanitize-log": completed})
+
+	if got := testutil.CollectAndCount(c, "nvme_sanitize_estimated_remaining_seconds"); got != 0 {
+		t.Errorf("collected %d remaining time metrics without a running sanitize", got)
+	}
+}
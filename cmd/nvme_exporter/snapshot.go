@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+// deviceSnapshot holds the nvme-cli output gathered for a single device during
+// one collection cycle.
+type deviceSnapshot struct {
+	info        gjson.Result
+	smartLog    gjson.Result
+	ocpSmartLog gjson.Result
+	identity    deviceIdentity
+	logs        map[string]gjson.Result
+	raw         map[string][]byte
+	sanitizing  bool
+	errors      []string
+	collectedAt time.Time
+	// cycle is the start of the collection cycle, shared by the devices
+	// collected in it.
+	cycle time.Time
+}
+
+// logPageCollector exports metrics from additional log pages. The queries it
+// needs run once per device and cycle, shared with other log page collectors,
+// and their output is stored in deviceSnapshot.logs by query name, or in
+// deviceSnapshot.raw for _binaryQueries. queries is
+// called again once they ran, so it can ask for log pages discovered from the
+// output of earlier ones.
+type logPageCollector interface {
+	queries(device deviceSnapshot) []string
+	describe(ch chan<- *prometheus.Desc)
+	send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string)
+}
+
+// logPageUpdater is implemented by log page collectors keeping state across
+// cycles. update runs once per collected device, after its queries, so sending
+// the same snapshot again to the JSON API or the dump command does not count
+// anything twice.
+type logPageUpdater interface {
+	update(device deviceSnapshot)
+}
+
+// withLabels returns the device label values followed by extra ones, without
+// modifying the shared device labels.
+func withLabels(labels []string, extra ...string) []string {
+	return append(append(make([]string, 0, len(labels)+len(extra)), labels...), extra...)
+}
+
+// snapshot is the result of one collection cycle. It is shared by the
+// Prometheus collector and the JSON API so both expose the same data without
+// issuing extra nvme commands.
+type snapshot struct {
+	devices     []deviceSnapshot
+	errors      []string
+	collectedAt time.Time
+}
+
+// refresh runs a full collection cycle and stores it as the latest snapshot.
+func (c *nvmeCollector) refresh() *snapshot {
+	snap := &snapshot{collectedAt: time.Now()}
+
+	nvmeDeviceList, err := c.listDevices()
+	if err != nil {
+		log.Println(err)
+		snap.errors = append(snap.errors, err.Error())
+	}
+
+	for _, nvmeDevice := range nvmeDeviceList {
+		if c.device != "" && nvmeDevice.Get("DevicePath").String() != c.device {
+			continue
+		}
+
+		snap.devices = append(snap.devices, c.collectDevice(nvmeDevice, snap.collectedAt))
+	}
+
+	c.mu.Lock()
+	c.last = snap
+	c.mu.Unlock()
+
+	return snap
+}
+
+// latest returns the most recent snapshot, collecting one if none exists yet.
+func (c *nvmeCollector) latest() *snapshot {
+	c.mu.Lock()
+	snap := c.last
+	c.mu.Unlock()
+
+	if snap == nil {
+		return c.refresh()
+	}
+
+	return snap
+}
+
+func (c *nvmeCollector) collectDevice(nvmeDevice gjson.Result, cycle time.Time) deviceSnapshot {
+	device := deviceSnapshot{
+		info:        nvmeDevice,
+		identity:    c.identity.lookupIdentity(nvmeDevice),
+		logs:        map[string]gjson.Result{},
+		raw:         map[string][]byte{},
+		collectedAt: time.Now(),
+		cycle:       cycle,
+	}
+	devicePath := nvmeDevice.Get("DevicePath").String()
+
+	smartLog, err := c.getSmartLog(devicePath)
+	if err != nil {
+		log.Println(err)
+		device.errors = append(device.errors, err.Error())
+	}
+
+	device.smartLog = smartLog
+
+	// The SMART log can be read during a sanitize operation, most other admin
+	// commands are aborted until it completes.
+	if c.sanitize {
+		c.runLogQueries(&device, []string{"sanitize-log"})
+		device.sanitizing = sanitizing(device)
+	}
+
+	if device.sanitizing {
+		log.Printf("Sanitize in progress on %s, skipping other log pages\n", devicePath)
+
+		return device
+	}
+
+	if c.ocp {
+		ocpSmartLog, err := c.getOcpSmartLog(devicePath)
+		if err != nil {
+			log.Println(err)
+			device.errors = append(device.errors, err.Error())
+		}
+
+		device.ocpSmartLog = ocpSmartLog
+	}
+
+	for _, page := range c.logPages {
+		for ran := true; ran; {
+			ran = c.runLogQueries(&device, page.queries(device))
+		}
+
+		if updater, ok := page.(logPageUpdater); ok {
+			updater.update(device)
+		}
+	}
+
+	return device
+}
+
+// runLogQueries runs the queries not yet in the device logs and reports
+// whether there were any. Failed queries are stored empty so they run once.
+func (c *nvmeCollector) runLogQueries(device *deviceSnapshot, queries []string) bool {
+	devicePath := device.info.Get("DevicePath").String()
+	ran := false
+
+	for _, query := range queries {
+		if _, ok := device.logs[query]; ok {
+			continue
+		}
+
+		output, err := c.run(query, devicePath)
+		if err != nil {
+			err = fmt.Errorf("error running %s %s: %w", query, devicePath, err)
+			log.Println(err)
+			device.errors = append(device.errors, err.Error())
+		}
+
+		if isBinaryQuery(query) {
+			device.raw[query] = output
+			output = nil
+		}
+
+		device.logs[query] = gjson.ParseBytes(output)
+		ran = true
+	}
+
+	return ran
+}
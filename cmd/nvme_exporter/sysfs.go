@@ -0,0 +1,185 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// millidegrees converts hwmon temperatures to degrees Celsius.
+const millidegrees = 1000
+
+// _controllerStates are the controller states reported in sysfs, see
+// nvme_sysfs_show_state in drivers/nvme/host/sysfs.c.
+var _controllerStates = []string{"new", "live", "resetting", "connecting", "deleting", "deleting (no IO)", "dead"}
+
+// sysfsCollector exports controller data from /sys/class/nvme. It issues no
+// admin commands, so it keeps working without nvme-cli or while a controller
+// does not respond to them.
+type sysfsCollector struct {
+	identity                    identityConfig
+	nvmeControllerState         *prometheus.Desc
+	nvmeControllerInfo          *prometheus.Desc
+	nvmeControllerQueueCount    *prometheus.Desc
+	nvmeControllerNumaNode      *prometheus.Desc
+	nvmeControllerHwmonTemp     *prometheus.Desc
+	nvmeControllerHwmonTempCrit *prometheus.Desc
+}
+
+func newSysfsCollector(identity identityConfig) *sysfsCollector {
+	labels := identity.labelNames()
+	sensorLabels := withLabels(labels, "sensor")
+
+	return &sysfsCollector{
+		identity: identity,
+		nvmeControllerState: prometheus.NewDesc(
+			"nvme_controller_state",
+			"Controller state reported by the kernel, 1 for the current state",
+			withLabels(labels, "state"),
+			nil,
+		),
+		nvmeControllerInfo: prometheus.NewDesc(
+			"nvme_controller_info",
+			"Controller identity and transport reported by the kernel",
+			identity.infoLabelNames("model_number", "firmware", "transport", "address", "cntlid"),
+			nil,
+		),
+		nvmeControllerQueueCount: prometheus.NewDesc(
+			"nvme_controller_queue_count",
+			"Number of I/O queues including the admin queue",
+			labels,
+			nil,
+		),
+		nvmeControllerNumaNode: prometheus.NewDesc(
+			"nvme_controller_numa_node",
+			"NUMA node the controller is attached to, -1 if unknown",
+			labels,
+			nil,
+		),
+		nvmeControllerHwmonTemp: prometheus.NewDesc(
+			"nvme_controller_hwmon_temperature_celsius",
+			"Temperature sensor reading from the kernel hwmon interface",
+			sensorLabels,
+			nil,
+		),
+		nvmeControllerHwmonTempCrit: prometheus.NewDesc(
+			"nvme_controller_hwmon_temperature_critical_celsius",
+			"Critical temperature threshold from the kernel hwmon interface",
+			sensorLabels,
+			nil,
+		),
+	}
+}
+
+func (c *sysfsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeControllerState
+	ch <- c.nvmeControllerInfo
+	ch <- c.nvmeControllerQueueCount
+	ch <- c.nvmeControllerNumaNode
+	ch <- c.nvmeControllerHwmonTemp
+	ch <- c.nvmeControllerHwmonTempCrit
+}
+
+// readSysfs returns the trimmed content of a sysfs attribute, or "" when it
+// cannot be read.
+func readSysfs(path string) string {
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+// readSysfsFloat returns a numeric sysfs attribute and whether it was present.
+func readSysfsFloat(path string) (float64, bool) {
+	value, err := strconv.ParseFloat(readSysfs(path), 64)
+
+	return value, err == nil
+}
+
+// controllerDirs returns the sysfs directories of all NVMe controllers.
+func controllerDirs(root string) []string {
+	controllers, err := filepath.Glob(filepath.Join(root, "class", "nvme", "nvme[0-9]*"))
+	if err != nil {
+		log.Printf("Error listing NVMe controllers in sysfs: %s\n", err)
+	}
+
+	return controllers
+}
+
+func (c *sysfsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, controller := range controllerDirs(c.identity.sysRoot) {
+		identity := c.identity.controllerIdentity(filepath.Base(controller))
+		labels := c.identity.labelValues(identity, "1")
+
+		c.sendState(ch, controller, labels)
+		ch <- prometheus.MustNewConstMetric(c.nvmeControllerInfo, prometheus.GaugeValue, 1,
+			c.identity.infoLabelValues(identity, "1",
+				readSysfs(filepath.Join(controller, "model")),
+				readSysfs(filepath.Join(controller, "firmware_rev")),
+				readSysfs(filepath.Join(controller, "transport")),
+				readSysfs(filepath.Join(controller, "address")),
+				readSysfs(filepath.Join(controller, "cntlid")))...)
+
+		if queueCount, ok := readSysfsFloat(filepath.Join(controller, "queue_count")); ok {
+			ch <- prometheus.MustNewConstMetric(c.nvmeControllerQueueCount, prometheus.GaugeValue, queueCount, labels...)
+		}
+
+		if numaNode, ok := readSysfsFloat(filepath.Join(controller, "numa_node")); ok {
+			ch <- prometheus.MustNewConstMetric(c.nvmeControllerNumaNode, prometheus.GaugeValue, numaNode, labels...)
+		}
+
+		c.sendHwmon(ch, controller, labels)
+	}
+}
+
+func (c *sysfsCollector) sendState(ch chan<- prometheus.Metric, controller string, labels []string) {
+	state := readSysfs(filepath.Join(controller, "state"))
+	known := false
+
+	for _, candidate := range _controllerStates {
+		value := 0.0
+		if candidate == state {
+			value = 1
+			known = true
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeControllerState, prometheus.GaugeValue, value, withLabels(labels, candidate)...)
+	}
+
+	if !known && state != "" {
+		ch <- prometheus.MustNewConstMetric(c.nvmeControllerState, prometheus.GaugeValue, 1, withLabels(labels, state)...)
+	}
+}
+
+// sendHwmon exports the controller hwmon sensors. Depending on the kernel
+// version the hwmon device hangs off the controller or its parent device.
+func (c *sysfsCollector) sendHwmon(ch chan<- prometheus.Metric, controller string, labels []string) {
+	inputs, _ := filepath.Glob(filepath.Join(controller, "hwmon*", "temp*_input"))
+	parentInputs, _ := filepath.Glob(filepath.Join(controller, "device", "hwmon", "hwmon*", "temp*_input"))
+
+	for _, input := range append(inputs, parentInputs...) {
+		prefix := strings.TrimSuffix(input, "_input")
+
+		sensor := readSysfs(prefix + "_label")
+		if sensor == "" {
+			sensor = filepath.Base(prefix)
+		}
+
+		if temp, ok := readSysfsFloat(input); ok {
+			ch <- prometheus.MustNewConstMetric(
+				c.nvmeControllerHwmonTemp, prometheus.GaugeValue, temp/millidegrees, withLabels(labels, sensor)...)
+		}
+
+		if crit, ok := readSysfsFloat(prefix + "_crit"); ok {
+			ch <- prometheus.MustNewConstMetric(
+				c.nvmeControllerHwmonTempCrit, prometheus.GaugeValue, crit/millidegrees, withLabels(labels, sensor)...)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// writeTestFiles creates the files under root, with their parent directories.
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for path, content := range files {
+		path = filepath.Join(root, path)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path, []byte(content+"\n"), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testIdentity returns the default identity configuration reading sysRoot,
+// without /dev/disk/by-id links.
+func testIdentity(sysRoot string) identityConfig {
+	identity := _defaultIdentity
+	identity.sysRoot = sysRoot
+	identity.byIDDir = filepath.Join(sysRoot, "by-id")
+
+	return identity
+}
+
+// testSysfs returns a sysfs tree with a PCIe controller reporting every
+// attribute and a fabrics controller reporting almost none.
+func testSysfs(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	pciDevice := "devices/pci0000:00/0000:00:01.1/0000:01:00.0"
+
+	writeTestFiles(t, root, map[string]string{
+		"class/nvme/nvme0/state":                "live",
+		"class/nvme/nvme0/model":                "SAMSUNG MZQL2960HCJR-00A07",
+		"class/nvme/nvme0/serial":               "S64FNE0R800001      ",
+		"class/nvme/nvme0/firmware_rev":         "GDC5302Q",
+		"class/nvme/nvme0/transport":            "pcie",
+		"class/nvme/nvme0/address":              "0000:01:00.0",
+		"class/nvme/nvme0/cntlid":               "6",
+		"class/nvme/nvme0/queue_count":          "65",
+		"class/nvme/nvme0/numa_node":            "-1",
+		"class/nvme/nvme0/hwmon2/temp1_input":   "38850",
+		"class/nvme/nvme0/hwmon2/temp1_label":   "Composite",
+		"class/nvme/nvme0/hwmon2/temp1_crit":    "84850",
+		"class/nvme/nvme0/hwmon2/temp2_input":   "45850",
+		"class/nvme/nvme0/hwmon2/temp2_label":   "Sensor 1",
+		pciDevice + "/current_link_speed":       "16.0 GT/s PCIe",
+		pciDevice + "/max_link_speed":           "16.0 GT/s PCIe",
+		pciDevice + "/current_link_width":       "4",
+		pciDevice + "/max_link_width":           "4",
+		pciDevice + "/aer_dev_correctable":      "RxErr 0\nBadTLP 2\nBadDLLP 1\nTOTAL_ERR_COR 3",
+		pciDevice + "/aer_dev_fatal":            "Undefined 0\nDLP 0\nTOTAL_ERR_FATAL 0",
+		"class/nvme/nvme1/state":                "connecting",
+		"class/nvme/nvme1/transport":            "tcp",
+		"class/nvme/nvme1/hwmon3/temp1_input":   "not a number",
+		"class/nvme/nvme10/state":               "suspended",
+		"class/nvme/nvme10/device/hwmon/README": "",
+	})
+
+	err := os.Symlink(filepath.Join(root, pciDevice), filepath.Join(root, "class/nvme/nvme0/device"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+func TestSysfsCollector(t *testing.T) {
+	expected := `
+# HELP nvme_controller_state Controller state reported by the kernel, 1 for the current state
+# TYPE nvme_controller_state gauge
+nvme_controller_state{device="/dev/nvme0",state="connecting"} 0
+nvme_controller_state{device="/dev/nvme0",state="dead"} 0
+nvme_controller_state{device="/dev/nvme0",state="deleting"} 0
+nvme_controller_state{device="/dev/nvme0",state="deleting (no IO)"} 0
+nvme_controller_state{device="/dev/nvme0",state="live"} 1
+nvme_controller_state{device="/dev/nvme0",state="new"} 0
+nvme_controller_state{device="/dev/nvme0",state="resetting"} 0
+nvme_controller_state{device="/dev/nvme1",state="connecting"} 1
+nvme_controller_state{device="/dev/nvme1",state="dead"} 0
+nvme_controller_state{device="/dev/nvme1",state="deleting"} 0
+nvme_controller_state{device="/dev/nvme1",state="deleting (no IO)"} 0
+nvme_controller_state{device="/dev/nvme1",state="live"} 0
+nvme_controller_state{device="/dev/nvme1",state="new"} 0
+nvme_controller_state{device="/dev/nvme1",state="resetting"} 0
+nvme_controller_state{device="/dev/nvme10",state="connecting"} 0
+nvme_controller_state{device="/dev/nvme10",state="dead"} 0
+nvme_controller_state{device="/dev/nvme10",state="deleting"} 0
+nvme_controller_state{device="/dev/nvme10",state="deleting (no IO)"} 0
+nvme_controller_state{device="/dev/nvme10",state="live"} 0
+nvme_controller_state{device="/dev/nvme10",state="new"} 0
+nvme_controller_state{device="/dev/nvme10",state="resetting"} 0
+nvme_controller_state{device="/dev/nvme10",state="suspended"} 1
+# HELP nvme_controller_info Controller identity and transport reported by the kernel
+# TYPE nvme_controller_info gauge
+nvme_controller_info{address="0000:01:00.0",cntlid="6",device="/dev/nvme0",firmware="GDC5302Q",model_number="SAMSUNG MZQL2960HCJR-00A07",serial_number="S64FNE0R800001",transport="pcie"} 1
+nvme_controller_info{address="",cntlid="",device="/dev/nvme1",firmware="",model_number="",serial_number="",transport="tcp"} 1
+nvme_controller_info{address="",cntlid="",device="/dev/nvme10",firmware="",model_number="",serial_number="",transport=""} 1
+# HELP nvme_controller_queue_count Number of I/O queues including the admin queue
+# TYPE nvme_controller_queue_count gauge
+nvme_controller_queue_count{device="/dev/nvme0"} 65
+# HELP nvme_controller_numa_node NUMA node the controller is attached to, -1 if unknown
+# TYPE nvme_controller_numa_node gauge
+nvme_controller_numa_node{device="/dev/nvme0"} -1
+# HELP nvme_controller_hwmon_temperature_celsius Temperature sensor reading from the kernel hwmon interface
+# TYPE nvme_controller_hwmon_temperature_celsius gauge
+nvme_controller_hwmon_temperature_celsius{device="/dev/nvme0",sensor="Composite"} 38.85
+nvme_controller_hwmon_temperature_celsius{device="/dev/nvme0",sensor="Sensor 1"} 45.85
+# HELP nvme_controller_hwmon_temperature_critical_celsius Critical temperature threshold from the kernel hwmon interface
+# TYPE nvme_controller_hwmon_temperature_critical_celsius gauge
+nvme_controller_hwmon_temperature_critical_celsius{device="/dev/nvme0",sensor="Composite"} 84.85
+`
+
+	err := testutil.CollectAndCompare(newSysfsCollector(testIdentity(testSysfs(t))), strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSysfsIdentityLabels(t *testing.T) {
+	identity := testIdentity(testSysfs(t))
+	identity.primary = identitySerial
+	identity.labels = true
+
+	expected := `
+# HELP nvme_controller_info Controller identity and transport reported by the kernel
+# TYPE nvme_controller_info gauge
+nvme_controller_info{address="0000:01:00.0",by_id_path="",cntlid="6",device="S64FNE0R800001",eui64="",firmware="GDC5302Q",model_number="SAMSUNG MZQL2960HCJR-00A07",nguid="",serial_number="S64FNE0R800001",transport="pcie",uuid=""} 1
+nvme_controller_info{address="",by_id_path="",cntlid="",device="/dev/nvme1",eui64="",firmware="",model_number="",nguid="",serial_number="",transport="tcp",uuid=""} 1
+nvme_controller_info{address="",by_id_path="",cntlid="",device="/dev/nvme10",eui64="",firmware="",model_number="",nguid="",serial_number="",transport="",uuid=""} 1
+# HELP nvme_controller_queue_count Number of I/O queues including the admin queue
+# TYPE nvme_controller_queue_count gauge
+nvme_controller_queue_count{by_id_path="",device="S64FNE0R800001",eui64="",nguid="",serial_number="S64FNE0R800001",uuid=""} 65
+`
+
+	err := testutil.CollectAndCompare(newSysfsCollector(identity), strings.NewReader(expected),
+		"nvme_controller_info", "nvme_controller_queue_count")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP nvme_pcie_link_width Current number of PCIe lanes
+# TYPE nvme_pcie_link_width gauge
+nvme_pcie_link_width{address="0000:01:00.0",by_id_path="",device="S64FNE0R800001",eui64="",nguid="",serial_number="S64FNE0R800001",uuid=""} 4
+`
+
+	err = testutil.CollectAndCompare(newPcieCollector(identity), strings.NewReader(expected), "nvme_pcie_link_width")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSysfsDualPortIdentity(t *testing.T) {
+	sysRoot := t.TempDir()
+	writeTestFiles(t, sysRoot, map[string]string{
+		"class/nvme/nvme0/state":  "live",
+		"class/nvme/nvme0/serial": "S1",
+		"class/nvme/nvme0/cntlid": "1",
+		"class/nvme/nvme1/state":  "connecting",
+		"class/nvme/nvme1/serial": "S1",
+		"class/nvme/nvme1/cntlid": "2",
+		"class/nvme/nvme2/state":  "live",
+		"class/nvme/nvme2/serial": "S2",
+		"class/nvme/nvme2/cntlid": "1",
+	})
+
+	identity := testIdentity(sysRoot)
+	identity.primary = identitySerial
+
+	for name, want := range map[string]string{"nvme0": "S1-c1", "nvme1": "S1-c2", "nvme2": "S2"} {
+		if got := identity.controllerLabels(name)[0]; got != want {
+			t.Errorf("device label of %s = %q, want %q", name, got, want)
+		}
+	}
+
+	// Duplicate series of the two controllers would fail the gathering.
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newSysfsCollector(identity))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() == "nvme_controller_state" && len(family.GetMetric()) != 3*len(_controllerStates) {
+			t.Errorf("%d controller states, want %d", len(family.GetMetric()), 3*len(_controllerStates))
+		}
+	}
+}
+
+func TestPcieCollector(t *testing.T) {
+	expected := `
+# HELP nvme_pcie_link_speed_gts Current PCIe link speed in GT/s
+# TYPE nvme_pcie_link_speed_gts gauge
+nvme_pcie_link_speed_gts{address="0000:01:00.0",device="/dev/nvme0"} 16
+# HELP nvme_pcie_link_max_speed_gts Maximum PCIe link speed supported in GT/s
+# TYPE nvme_pcie_link_max_speed_gts gauge
+nvme_pcie_link_max_speed_gts{address="0000:01:00.0",device="/dev/nvme0"} 16
+# HELP nvme_pcie_link_width Current number of PCIe lanes
+# TYPE nvme_pcie_link_width gauge
+nvme_pcie_link_width{address="0000:01:00.0",device="/dev/nvme0"} 4
+# HELP nvme_pcie_link_max_width Maximum number of PCIe lanes supported
+# TYPE nvme_pcie_link_max_width gauge
+nvme_pcie_link_max_width{address="0000:01:00.0",device="/dev/nvme0"} 4
+# HELP nvme_pcie_aer_errors_total PCIe Advanced Error Reporting errors by severity and type
+# TYPE nvme_pcie_aer_errors_total counter
+nvme_pcie_aer_errors_total{address="0000:01:00.0",device="/dev/nvme0",severity="correctable",type="BadDLLP"} 1
+nvme_pcie_aer_errors_total{address="0000:01:00.0",device="/dev/nvme0",severity="correctable",type="BadTLP"} 2
+nvme_pcie_aer_errors_total{address="0000:01:00.0",device="/dev/nvme0",severity="correctable",type="RxErr"} 0
+nvme_pcie_aer_errors_total{address="0000:01:00.0",device="/dev/nvme0",severity="fatal",type="DLP"} 0
+nvme_pcie_aer_errors_total{address="0000:01:00.0",device="/dev/nvme0",severity="fatal",type="Undefined"} 0
+`
+
+	err := testutil.CollectAndCompare(newPcieCollector(testIdentity(testSysfs(t))), strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseLinkSpeed(t *testing.T) {
+	for speed, want := range map[string]float64{
+		"2.5 GT/s PCIe": 2.5,
+		"8.0 GT/s PCIe": 8,
+		"32.0 GT/s":     32,
+	} {
+		got, ok := parseLinkSpeed(speed)
+		if !ok || got != want {
+			t.Errorf("parseLinkSpeed(%q) = %v, %v, want %v", speed, got, ok, want)
+		}
+	}
+
+	if _, ok := parseLinkSpeed("Unknown"); ok {
+		t.Error("parseLinkSpeed(\"Unknown\") succeeded")
+	}
+}
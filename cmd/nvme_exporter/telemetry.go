@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// lpaTelemetry is the id-ctrl LPA bit set by controllers supporting the
+	// telemetry log pages.
+	lpaTelemetry = 1 << 3
+	// Offsets in the telemetry log header, see the Telemetry Host-Initiated
+	// and Controller-Initiated log pages in the NVMe base specification.
+	telemetryHeaderSize       = 512
+	telemetryDataArea1Offset  = 8
+	telemetryDataArea4Offset  = 16
+	telemetryCtrlAvailOffset  = 382
+	telemetryCtrlGenOffset    = 383
+	telemetryBlockSize        = 512
+	telemetryDefaultDataArea  = 3
+	telemetryMaxDataArea      = 4
+	telemetryCaptureFileMode  = 0o600
+	telemetryCaptureTimestamp = "20060102T150405Z"
+)
+
+// telemetryCollector exports whether the controller saved controller-initiated
+// telemetry data, which vendors ask for when analysing a failure.
+type telemetryCollector struct {
+	nvmeTelemetryCtrlAvailable  *prometheus.Desc
+	nvmeTelemetryCtrlGeneration *prometheus.Desc
+}
+
+func newTelemetryCollector(labels []string) *telemetryCollector {
+	return &telemetryCollector{
+		nvmeTelemetryCtrlAvailable: prometheus.NewDesc(
+			"nvme_telemetry_controller_data_available",
+			"Whether the controller saved controller-initiated telemetry data not yet read by the host",
+			labels,
+			nil,
+		),
+		nvmeTelemetryCtrlGeneration: prometheus.NewDesc(
+			"nvme_telemetry_controller_data_generation",
+			"Generation number of the controller-initiated telemetry data, incremented on every new capture",
+			labels,
+			nil,
+		),
+	}
+}
+
+// supportsTelemetry reports whether id-ctrl ran and advertises the telemetry
+// log pages.
+func supportsTelemetry(device deviceSnapshot) bool {
+	idCtrl, ok := device.logs["id-ctrl"]
+	if !ok {
+		return false
+	}
+
+	lpa, _ := logUint(idCtrl.Get("lpa"))
+
+	return lpa&lpaTelemetry != 0
+}
+
+func (c *telemetryCollector) queries(device deviceSnapshot) []string {
+	if supportsTelemetry(device) {
+		return []string{"id-ctrl", "telemetry-ctrl-header"}
+	}
+
+	return []string{"id-ctrl"}
+}
+
+func (c *telemetryCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeTelemetryCtrlAvailable
+	ch <- c.nvmeTelemetryCtrlGeneration
+}
+
+func (c *telemetryCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	header := device.raw["telemetry-ctrl-header"]
+	if len(header) < telemetryHeaderSize {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.nvmeTelemetryCtrlAvailable, prometheus.GaugeValue, float64(header[telemetryCtrlAvailOffset]), labels...)
+	ch <- prometheus.MustNewConstMetric(
+		c.nvmeTelemetryCtrlGeneration, prometheus.GaugeValue, float64(header[telemetryCtrlGenOffset]), labels...)
+}
+
+// telemetryAreaSize returns the size of the telemetry log up to and including
+// the data area, from the last block numbers in the log header.
+func telemetryAreaSize(header []byte, area int) int64 {
+	var lastBlock uint32
+	if area == telemetryMaxDataArea {
+		lastBlock = binary.LittleEndian.Uint32(header[telemetryDataArea4Offset:])
+	} else {
+		lastBlock = uint32(binary.LittleEndian.Uint16(header[telemetryDataArea1Offset+2*(area-1):]))
+	}
+
+	return (int64(lastBlock) + 1) * telemetryBlockSize
+}
+
+// _captureNameRe matches the characters replaced in capture file names.
+var _captureNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// telemetryCapture serves the admin endpoint writing telemetry logs to a
+// directory. Requests need the bearer token, only one capture runs at a time
+// and each device can be captured once per interval.
+type telemetryCapture struct {
+	mu        sync.Mutex
+	collector *nvmeCollector
+	dir       string
+	token     string
+	maxBytes  int64
+	interval  time.Duration
+	last      map[string]time.Time
+}
+
+// telemetryCaptureResult is the capture endpoint response.
+type telemetryCaptureResult struct {
+	Device string `json:"device"`
+	Type   string `json:"type"`
+	File   string `json:"file"`
+	Bytes  int64  `json:"bytes"`
+}
+
+func newTelemetryCapture(
+	collector *nvmeCollector, dir, tokenFile string, maxBytes int64, interval time.Duration,
+) (*telemetryCapture, error) {
+	if tokenFile == "" {
+		return nil, errors.New("telemetry capture needs a token file")
+	}
+
+	token, err := os.ReadFile(filepath.Clean(tokenFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading telemetry capture token: %w", err)
+	}
+
+	if strings.TrimSpace(string(token)) == "" {
+		return nil, fmt.Errorf("telemetry capture token file %s is empty", tokenFile)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("telemetry capture directory %s is not a directory", dir)
+	}
+
+	// The commands only write capture files given by an absolute path.
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving telemetry capture directory: %w", err)
+	}
+
+	return &telemetryCapture{
+		collector: collector,
+		dir:       dir,
+		token:     strings.TrimSpace(string(token)),
+		maxBytes:  maxBytes,
+		interval:  interval,
+		last:      map[string]time.Time{},
+	}, nil
+}
+
+func (t *telemetryCapture) authorized(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(t.token)) == 1
+}
+
+// capture handles POST /api/v1/devices/{serial}/telemetry, with the optional
+// type (host or controller) and data_area (1 to 4) query parameters.
+func (t *telemetryCapture) capture(w http.ResponseWriter, r *http.Request) {
+	if !t.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+
+		return
+	}
+
+	captureType := r.URL.Query().Get("type")
+	if captureType == "" {
+		captureType = "host"
+	}
+
+	area := telemetryDefaultDataArea
+	if value := r.URL.Query().Get("data_area"); value != "" {
+		area, _ = strconv.Atoi(value)
+	}
+
+	if (captureType != "host" && captureType != "controller") || area < 1 || area > telemetryMaxDataArea {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "type must be host or controller, " +
+			"data_area 1 to 4"})
+
+		return
+	}
+
+	device, ok := t.findDevice(r.PathValue("serial"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "device not found: " + r.PathValue("serial")})
+
+		return
+	}
+
+	if !t.mu.TryLock() {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "a capture is already running"})
+
+		return
+	}
+	defer t.mu.Unlock()
+
+	serial := device.info.Get("SerialNumber").String()
+	if wait := t.interval - time.Since(t.last[serial]); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "device captured too recently"})
+
+		return
+	}
+
+	status, body := t.run(device, captureType, area)
+	if status == http.StatusCreated {
+		t.last[serial] = time.Now()
+	}
+
+	log.Printf("Telemetry capture of %s, %s data area %d, requested by %s: %d %s\n",
+		serial, captureType, area, r.RemoteAddr, status, http.StatusText(status))
+	writeJSON(w, status, body)
+}
+
+func (t *telemetryCapture) findDevice(serial string) (deviceSnapshot, bool) {
+	for _, device := range t.collector.latest().devices {
+		if device.info.Get("SerialNumber").String() == serial {
+			return device, true
+		}
+	}
+
+	return deviceSnapshot{}, false
+}
+
+// run captures the telemetry log. Its size is checked against the header
+// first, the controller-initiated one read during collection and the
+// host-initiated one read now. That one describes the previous capture, the
+// data areas of a new one may differ, so the file is checked again afterwards.
+func (t *telemetryCapture) run(device deviceSnapshot, captureType string, area int) (int, interface{}) {
+	if device.sanitizing {
+		return http.StatusConflict, map[string]string{"error": "sanitize in progress"}
+	}
+
+	devicePath := device.info.Get("DevicePath").String()
+
+	args := []string{"--host-generate", "1"}
+	header := device.raw["telemetry-ctrl-header"]
+
+	if captureType == "controller" {
+		if len(header) < telemetryHeaderSize || header[telemetryCtrlAvailOffset] == 0 {
+			return http.StatusConflict, map[string]string{"error": "no controller-initiated telemetry data available"}
+		}
+
+		args = []string{"--controller-init"}
+	} else {
+		var err error
+
+		header, err = t.collector.run("telemetry-host-header", devicePath)
+		if err != nil {
+			return http.StatusInternalServerError, map[string]string{
+				"error": fmt.Sprintf("error reading the telemetry log header: %s", err),
+			}
+		}
+	}
+
+	if len(header) >= telemetryHeaderSize {
+		if size := telemetryAreaSize(header, area); size > t.maxBytes {
+			return http.StatusRequestEntityTooLarge, map[string]string{
+				"error": fmt.Sprintf("telemetry log of %d bytes exceeds the %d bytes limit", size, t.maxBytes),
+			}
+		}
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.bin", _captureNameRe.ReplaceAllString(device.info.Get("SerialNumber").String(), "_"),
+		captureType, time.Now().UTC().Format(telemetryCaptureTimestamp))
+	file := filepath.Join(t.dir, name)
+
+	args = append([]string{"telemetry-log", devicePath, "--output-file", file}, args...)
+
+	_, err := executeBinaryCommand("nvme", append(args, "--data-area", strconv.Itoa(area))...)
+	if err != nil {
+		_ = os.Remove(file)
+
+		return http.StatusInternalServerError, map[string]string{"error": err.Error()}
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return http.StatusInternalServerError, map[string]string{"error": err.Error()}
+	}
+
+	if info.Size() > t.maxBytes {
+		_ = os.Remove(file)
+
+		return http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("telemetry log of %d bytes exceeds the %d bytes limit", info.Size(), t.maxBytes),
+		}
+	}
+
+	_ = os.Chmod(file, telemetryCaptureFileMode)
+
+	return http.StatusCreated, telemetryCaptureResult{
+		Device: devicePath,
+		Type:   captureType,
+		File:   file,
+		Bytes:  info.Size(),
+	}
+}
+
+func registerTelemetryCapture(mux *http.ServeMux, capture *telemetryCapture) {
+	mux.HandleFunc("POST /api/v1/devices/{serial}/telemetry", capture.capture)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// testTelemetryHeader returns a telemetry log header with the last blocks of
+// data areas 1 to 4.
+func testTelemetryHeader(area1, area2, area3 uint16, area4 uint32) []byte {
+	header := make([]byte, telemetryHeaderSize)
+	binary.LittleEndian.PutUint16(header[telemetryDataArea1Offset:], area1)
+	binary.LittleEndian.PutUint16(header[telemetryDataArea1Offset+2:], area2)
+	binary.LittleEndian.PutUint16(header[telemetryDataArea1Offset+4:], area3)
+	binary.LittleEndian.PutUint32(header[telemetryDataArea4Offset:], area4)
+
+	return header
+}
+
+func TestTelemetryAreaSize(t *testing.T) {
+	header := testTelemetryHeader(7, 63, 2047, 1<<20)
+
+	for area, want := range map[int]int64{1: 4096, 2: 32768, 3: 1 << 20, 4: (1<<20 + 1) * 512} {
+		if got := telemetryAreaSize(header, area); got != want {
+			t.Errorf("telemetryAreaSize(area %d) = %d, want %d", area, got, want)
+		}
+	}
+}
+
+func TestTelemetryCaptureTooLarge(t *testing.T) {
+	var ran []string
+
+	collector := newNvmeCollector(false, testIdentity(t.TempDir()))
+	collector.run = func(query, _ string) ([]byte, error) {
+		ran = append(ran, query)
+
+		return testTelemetryHeader(7, 63, 2047, 4095), nil
+	}
+
+	capture := &telemetryCapture{collector: collector, dir: t.TempDir(), maxBytes: 1 << 19}
+	device := deviceSnapshot{
+		info: gjson.Parse(`{"DevicePath":"/dev/nvme0n1","SerialNumber":"S1"}`),
+		raw:  map[string][]byte{"telemetry-ctrl-header": testTelemetryHeader(7, 63, 2047, 4095)},
+	}
+	device.raw["telemetry-ctrl-header"][telemetryCtrlAvailOffset] = 1
+
+	for _, captureType := range []string{"host", "controller"} {
+		status, body := capture.run(device, captureType, 3)
+		if status != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s capture of data area 3 returned %d %v, want %d",
+				captureType, status, body, http.StatusRequestEntityTooLarge)
+		}
+	}
+
+	if want := []string{"telemetry-host-header"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestNewTelemetryCaptureRelativeDir(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+
+	err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relative, err := filepath.Rel(cwd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capture, err := newTelemetryCapture(nil, relative, tokenFile, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if capture.dir != dir || capture.token != "secret" {
+		t.Errorf("capture dir %q and token %q, want %q and secret", capture.dir, capture.token, dir)
+	}
+
+	if file := filepath.Join(capture.dir, "S1-host.bin"); !isCaptureFile(file) {
+		t.Errorf("capture file %s is not allowed", file)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// mountinfoSeparator ends the optional fields of a mountinfo line.
+const mountinfoSeparator = "-"
+
+// mount is a mounted filesystem from mountinfo. root is the directory of the
+// filesystem mounted, "/" unless it is a bind mount of a subdirectory.
+type mount struct {
+	root       string
+	mountpoint string
+	fstype     string
+}
+
+// usage is what a namespace ultimately backs: the block device at the top of
+// a holder chain, its mount and the md array or LVM volume group on the way.
+type usage struct {
+	holder     string
+	mountpoint string
+	fstype     string
+	mdArray    string
+	lvmVG      string
+}
+
+// parseMountinfo maps "major:minor" device numbers to their first mount of the
+// filesystem root, or their first bind mount when the root is not mounted, see
+// proc(5) for the /proc/<pid>/mountinfo format.
+func parseMountinfo(path string) (map[string]mount, error) {
+	mountinfo, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading mountinfo: %w", err)
+	}
+	defer mountinfo.Close()
+
+	mounts := map[string]mount{}
+
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for i := 6; i < len(fields)-1; i++ {
+			if fields[i] != mountinfoSeparator {
+				continue
+			}
+
+			if known, ok := mounts[fields[2]]; !ok || (known.root != "/" && fields[3] == "/") {
+				mounts[fields[2]] = mount{
+					root:       unescapeMountinfo(fields[3]),
+					mountpoint: unescapeMountinfo(fields[4]),
+					fstype:     fields[i+1],
+				}
+			}
+
+			break
+		}
+	}
+
+	return mounts, nil
+}
+
+// unescapeMountinfo decodes the octal escapes mountinfo uses for spaces,
+// tabs, newlines and backslashes in paths.
+func unescapeMountinfo(path string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(path)
+}
+
+// lvmVolumeGroup extracts the volume group from a device-mapper name such as
+// "vg--data-lv_root", where dashes inside names are doubled.
+func lvmVolumeGroup(dmName string) string {
+	for i := 0; i < len(dmName); i++ {
+		if dmName[i] != '-' {
+			continue
+		}
+
+		if i+1 < len(dmName) && dmName[i+1] == '-' {
+			i++
+
+			continue
+		}
+
+		return strings.ReplaceAll(dmName[:i], "--", "-")
+	}
+
+	return ""
+}
+
+// usageCollector exports which filesystems, md arrays and LVM volume groups
+// each NVMe namespace backs.
+type usageCollector struct {
+	procRoot        string
+	identity        identityConfig
+	nvmeDeviceUsage *prometheus.Desc
+}
+
+func newUsageCollector(procRoot string, identity identityConfig) *usageCollector {
+	return &usageCollector{
+		procRoot: procRoot,
+		identity: identity,
+		nvmeDeviceUsage: prometheus.NewDesc(
+			"nvme_device_usage_info",
+			"Block device, mount, md array and LVM volume group backed by the namespace",
+			identity.infoLabelNames("holder", "mountpoint", "fstype", "md_array", "lvm_vg"),
+			nil,
+		),
+	}
+}
+
+func (c *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeDeviceUsage
+}
+
+// namespaceUsage is a usage of the namespace device.
+type namespaceUsage struct {
+	usage
+
+	device string
+}
+
+// namespaceUsages returns what every NVMe namespace on the host backs. The
+// mounts are those of PID 1, so that a container sharing the host PID
+// namespace, or given the host /proc as procRoot, sees the host mounts rather
+// than its own.
+func (c *usageCollector) namespaceUsages() []namespaceUsage {
+	mounts, err := parseMountinfo(filepath.Join(c.procRoot, "1", "mountinfo"))
+	if err != nil {
+		log.Println(err)
+	}
+
+	var usages []namespaceUsage
+
+	namespaces, _ := filepath.Glob(filepath.Join(c.identity.sysRoot, "block", "nvme[0-9]*"))
+	for _, namespace := range namespaces {
+		name := filepath.Base(namespace)
+		if !_namespaceRe.MatchString(name) {
+			continue
+		}
+
+		for _, used := range c.walk(namespace, usage{}, mounts) {
+			if used.holder == name {
+				used.holder = ""
+			}
+
+			// Partitions of the namespace in the same md array or volume
+			// group lead to the same usage.
+			if found := (namespaceUsage{usage: used, device: "/dev/" + name}); !slices.Contains(usages, found) {
+				usages = append(usages, found)
+			}
+		}
+	}
+
+	return usages
+}
+
+func (c *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, used := range c.namespaceUsages() {
+		identity, nameSpace := c.identity.namespaceIdentity(filepath.Base(used.device))
+
+		ch <- prometheus.MustNewConstMetric(c.nvmeDeviceUsage, prometheus.GaugeValue, 1, c.identity.infoLabelValues(
+			identity, nameSpace, used.holder, used.mountpoint, used.fstype, used.mdArray, used.lvmVG)...)
+	}
+}
+
+// walk follows partitions and holders from a block device directory down to
+// the devices nothing else is stacked on, recording md and LVM on the way.
+func (c *usageCollector) walk(dir string, used usage, mounts map[string]mount) []usage {
+	name := filepath.Base(dir)
+	used.holder = name
+	used.mountpoint = ""
+	used.fstype = ""
+
+	switch {
+	case strings.HasPrefix(name, "md"):
+		used.mdArray = name
+	case strings.HasPrefix(readSysfs(filepath.Join(dir, "dm", "uuid")), "LVM-"):
+		used.lvmVG = lvmVolumeGroup(readSysfs(filepath.Join(dir, "dm", "name")))
+	}
+
+	if mounted, ok := mounts[readSysfs(filepath.Join(dir, "dev"))]; ok {
+		used.mountpoint = mounted.mountpoint
+		used.fstype = mounted.fstype
+	}
+
+	// Partitions are subdirectories named after the parent, holders are
+	// symlinks to the stacked devices.
+	partitions, _ := filepath.Glob(filepath.Join(dir, name+"p[0-9]*"))
+	holders, _ := filepath.Glob(filepath.Join(dir, "holders", "*"))
+
+	var usages []usage
+
+	for _, next := range append(partitions, holders...) {
+		resolved, err := filepath.EvalSymlinks(next)
+		if err != nil {
+			continue
+		}
+
+		usages = append(usages, c.walk(resolved, used, mounts)...)
+	}
+
+	if len(usages) == 0 || used.mountpoint != "" {
+		usages = append(usages, used)
+	}
+
+	return usages
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testMountinfo = `22 1 259:5 / / rw,relatime shared:1 - xfs /dev/nvme2n1 rw,attr2,inode64
+95 22 259:5 /var/lib/kubelet /var/lib/kubelet rw,relatime shared:1 - xfs /dev/nvme2n1 rw,attr2
+98 22 0:44 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+101 22 253:0 /exports /srv/nfs\040exports rw,relatime shared:50 - ext4 /dev/mapper/vg--data-lv_home rw
+102 22 253:0 / /srv/my\040data rw,relatime shared:50 - ext4 /dev/mapper/vg--data-lv_home rw
+103 22 253:0 / /srv/second rw,relatime shared:50 - ext4 /dev/mapper/vg--data-lv_home rw
+110 22 259:9 / /scratch\011tab rw,relatime - xfs /dev/nvme4n1 rw
+`
+
+// testUsageTree returns a sysfs and a procfs tree where two nvme0n1 partitions
+// and an nvme1n1 partition are the members of an md array holding an LVM
+// volume mounted with a space in its path, nvme2n1 is the root filesystem, bind mounted
+// again, and nvme3n1 backs nothing.
+func testUsageTree(t *testing.T) *usageCollector {
+	t.Helper()
+
+	sysRoot := t.TempDir()
+	procRoot := t.TempDir()
+
+	writeTestFiles(t, procRoot, map[string]string{
+		"1/mountinfo":    testMountinfo,
+		"self/mountinfo": "1 0 0:1 / / rw - overlay overlay rw",
+	})
+	writeTestFiles(t, sysRoot, map[string]string{
+		"block/nvme0n1/dev":           "259:0",
+		"block/nvme0n1/device/serial": "S0",
+		"block/nvme0n1/nsid":          "1",
+		"block/nvme0n1/nvme0n1p1/dev": "259:1",
+		"block/nvme0n1/nvme0n1p2/dev": "259:4",
+		"block/nvme1n1/dev":           "259:2",
+		"block/nvme1n1/device/serial": "S1",
+		"block/nvme1n1/nsid":          "1",
+		"block/nvme1n1/nvme1n1p1/dev": "259:3",
+		"block/md0/dev":               "9:0",
+		"block/dm-0/dev":              "253:0",
+		"block/dm-0/dm/name":          "vg--data-lv_home",
+		"block/dm-0/dm/uuid":          "LVM-Uy8Nk3Aq9lW2fSd1Rb3GQpjtkYHXGcVjG0sUQm1bT1Nf2E4Vr8Jc6Lh5Xe7Pz9Ka",
+		"block/nvme2n1/dev":           "259:5",
+		"block/nvme2n1/device/serial": "S2",
+		"block/nvme2n1/nsid":          "1",
+		"block/nvme3n1/dev":           "259:7",
+		"block/nvme3n1/device/serial": "S3",
+		"block/nvme3n1/nsid":          "1",
+		"block/sda/dev":               "8:0",
+	})
+
+	for link, target := range map[string]string{
+		"block/nvme0n1/nvme0n1p1/holders/md0": "block/md0",
+		"block/nvme0n1/nvme0n1p2/holders/md0": "block/md0",
+		"block/nvme1n1/nvme1n1p1/holders/md0": "block/md0",
+		"block/md0/holders/dm-0":              "block/dm-0",
+	} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(sysRoot, link)), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.Symlink(filepath.Join(sysRoot, target), filepath.Join(sysRoot, link))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return newUsageCollector(procRoot, testIdentity(sysRoot))
+}
+
+func TestNamespaceUsages(t *testing.T) {
+	lvm := usage{holder: "dm-0", mountpoint: "/srv/my data", fstype: "ext4", mdArray: "md0", lvmVG: "vg-data"}
+
+	want := []namespaceUsage{
+		{usage: lvm, device: "/dev/nvme0n1"},
+		{usage: lvm, device: "/dev/nvme1n1"},
+		{usage: usage{mountpoint: "/", fstype: "xfs"}, device: "/dev/nvme2n1"},
+		{device: "/dev/nvme3n1"},
+	}
+
+	got := testUsageTree(t).namespaceUsages()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("namespaceUsages() = %+v, want %+v", got, want)
+	}
+}
+
+func TestUsageCollector(t *testing.T) {
+	tree := testUsageTree(t)
+	identity := tree.identity
+	identity.primary = identitySerial
+	identity.labels = true
+
+	expected := `
+# HELP nvme_device_usage_info Block device, mount, md array and LVM volume group backed by the namespace
+# TYPE nvme_device_usage_info gauge
+nvme_device_usage_info{by_id_path="",device="S0",eui64="",fstype="ext4",holder="dm-0",lvm_vg="vg-data",md_array="md0",mountpoint="/srv/my data",nguid="",serial_number="S0",uuid=""} 1
+nvme_device_usage_info{by_id_path="",device="S1",eui64="",fstype="ext4",holder="dm-0",lvm_vg="vg-data",md_array="md0",mountpoint="/srv/my data",nguid="",serial_number="S1",uuid=""} 1
+nvme_device_usage_info{by_id_path="",device="S2",eui64="",fstype="xfs",holder="",lvm_vg="",md_array="",mountpoint="/",nguid="",serial_number="S2",uuid=""} 1
+nvme_device_usage_info{by_id_path="",device="S3",eui64="",fstype="",holder="",lvm_vg="",md_array="",mountpoint="",nguid="",serial_number="S3",uuid=""} 1
+`
+
+	err := testutil.CollectAndCompare(newUsageCollector(tree.procRoot, identity), strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseMountinfo(t *testing.T) {
+	procRoot := t.TempDir()
+	writeTestFiles(t, procRoot, map[string]string{"mountinfo": testMountinfo})
+
+	mounts, err := parseMountinfo(filepath.Join(procRoot, "mountinfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for device, want := range map[string]mount{
+		"259:5": {root: "/", mountpoint: "/", fstype: "xfs"},
+		"253:0": {root: "/", mountpoint: "/srv/my data", fstype: "ext4"},
+		"259:9": {root: "/", mountpoint: "/scratch\ttab", fstype: "xfs"},
+		"0:44":  {root: "/", mountpoint: "/sys", fstype: "sysfs"},
+	} {
+		if mounts[device] != want {
+			t.Errorf("mount of %s = %+v, want %+v", device, mounts[device], want)
+		}
+	}
+}
+
+func TestParseMountinfoBindOnly(t *testing.T) {
+	procRoot := t.TempDir()
+	writeTestFiles(t, procRoot, map[string]string{
+		"mountinfo": "40 22 259:1 /data/export /export\\040share rw - ext4 /dev/nvme0n1p1 rw",
+	})
+
+	mounts, err := parseMountinfo(filepath.Join(procRoot, "mountinfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := mount{root: "/data/export", mountpoint: "/export share", fstype: "ext4"}
+	if mounts["259:1"] != want {
+		t.Errorf("mount = %+v, want %+v", mounts["259:1"], want)
+	}
+}
+
+func TestLvmVolumeGroup(t *testing.T) {
+	for dmName, want := range map[string]string{
+		"vg--data-lv_home":     "vg-data",
+		"vg0-root":             "vg0",
+		"my--vg-my--lv":        "my-vg",
+		"luks-0d1c2b3a":        "luks",
+		"novolumegroup":        "",
+		"vg--with--dashes-lv0": "vg-with-dashes",
+	} {
+		if got := lvmVolumeGroup(dmName); got != want {
+			t.Errorf("lvmVolumeGroup(%q) = %q, want %q", dmName, got, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// znsNoLimit is the maximum open or active resources of a namespace
+	// without a limit.
+	znsNoLimit = 0xffffffff
+	// flbasIndexMask and flbasIndexHighMask select the bits 3:0 and 6:5 of
+	// the id-ns FLBAS, the formatted LBA format index.
+	flbasIndexMask      = 0x0f
+	flbasIndexHighMask  = 0x60
+	flbasIndexHighShift = 1
+)
+
+// _zoneStates are the zone states, by the report-zones --state filter value.
+var _zoneStates = []struct {
+	filter int
+	state  string
+}{
+	{1, "empty"},
+	{2, "implicitly_open"},
+	{3, "explicitly_open"},
+	{4, "closed"},
+	{5, "full"},
+	{6, "read_only"},
+	{7, "offline"},
+}
+
+// znsCollector exports the zone states and resources of zoned namespaces.
+// Zones are counted without reading their descriptors: a report that is not
+// partial gives the number of zones matching its state filter, however few
+// descriptors fit, so one single descriptor report per state is enough on
+// drives with any number of zones.
+type znsCollector struct {
+	sysRoot                   string
+	nvmeZnsZones              *prometheus.Desc
+	nvmeZnsMaxOpenResources   *prometheus.Desc
+	nvmeZnsMaxActiveResources *prometheus.Desc
+	nvmeZnsZoneSize           *prometheus.Desc
+}
+
+func newZnsCollector(labels []string, sysRoot string) *znsCollector {
+	return &znsCollector{
+		sysRoot: sysRoot,
+		nvmeZnsZones: prometheus.NewDesc(
+			"nvme_zns_zones",
+			"Number of zones of the zoned namespace in the state",
+			withLabels(labels, "state"),
+			nil,
+		),
+		nvmeZnsMaxOpenResources: prometheus.NewDesc(
+			"nvme_zns_max_open_resources",
+			"Maximum number of zones that can be open at the same time, absent when unlimited",
+			labels,
+			nil,
+		),
+		nvmeZnsMaxActiveResources: prometheus.NewDesc(
+			"nvme_zns_max_active_resources",
+			"Maximum number of zones that can be open or closed at the same time, absent when unlimited",
+			labels,
+			nil,
+		),
+		nvmeZnsZoneSize: prometheus.NewDesc(
+			"nvme_zns_zone_size_bytes",
+			"Size of the zones of the zoned namespace",
+			labels,
+			nil,
+		),
+	}
+}
+
+// zoned reports whether the kernel sees the namespace as a zoned block device.
+// This needs no admin command, so other namespaces are not queried at all.
+func (c *znsCollector) zoned(device deviceSnapshot) bool {
+	name := filepath.Base(device.info.Get("DevicePath").String())
+
+	return readSysfs(filepath.Join(c.sysRoot, "block", name, "queue", "zoned")) == "host-managed"
+}
+
+func (c *znsCollector) queries(device deviceSnapshot) []string {
+	if !c.zoned(device) {
+		return nil
+	}
+
+	queries := []string{"id-ns", "zns-id-ns"}
+	for _, state := range _zoneStates {
+		queries = append(queries, "zns-report-zones:"+strconv.Itoa(state.filter))
+	}
+
+	return queries
+}
+
+func (c *znsCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nvmeZnsZones
+	ch <- c.nvmeZnsMaxOpenResources
+	ch <- c.nvmeZnsMaxActiveResources
+	ch <- c.nvmeZnsZoneSize
+}
+
+func (c *znsCollector) send(ch chan<- prometheus.Metric, device deviceSnapshot, labels []string) {
+	for _, state := range _zoneStates {
+		zones, ok := logUint(device.logs["zns-report-zones:"+strconv.Itoa(state.filter)].Get("nr_zones"))
+		if ok {
+			ch <- prometheus.MustNewConstMetric(
+				c.nvmeZnsZones, prometheus.GaugeValue, float64(zones), withLabels(labels, state.state)...)
+		}
+	}
+
+	znsIDNs, ok := device.logs["zns-id-ns"]
+	if !ok || !znsIDNs.IsObject() {
+		return
+	}
+
+	// Both resources are zero-based.
+	if resources, ok := logUint(znsIDNs.Get("mor")); ok && resources != znsNoLimit {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeZnsMaxOpenResources, prometheus.GaugeValue, float64(resources+1), labels...)
+	}
+
+	if resources, ok := logUint(znsIDNs.Get("mar")); ok && resources != znsNoLimit {
+		ch <- prometheus.MustNewConstMetric(
+			c.nvmeZnsMaxActiveResources, prometheus.GaugeValue, float64(resources+1), labels...)
+	}
+
+	if size, ok := zoneSize(device.logs["id-ns"], znsIDNs); ok {
+		ch <- prometheus.MustNewConstMetric(c.nvmeZnsZoneSize, prometheus.GaugeValue, float64(size), labels...)
+	}
+}
+
+// zoneSize returns the zone size in bytes, from the zone size in logical
+// blocks of the formatted LBA format extension and its logical block size.
+func zoneSize(idNs, znsIDNs gjson.Result) (uint64, bool) {
+	flbas, ok := logUint(idNs.Get("flbas"))
+	if !ok {
+		return 0, false
+	}
+
+	format := strconv.FormatUint(flbas&flbasIndexMask|(flbas&flbasIndexHighMask)>>flbasIndexHighShift, 10)
+
+	blocks, ok := logUint(znsIDNs.Get("lbafe." + format + ".zsze"))
+	if !ok {
+		return 0, false
+	}
+
+	blockSize, ok := logUint(idNs.Get("lbafs." + format + ".ds"))
+	if !ok {
+		return 0, false
+	}
+
+	return blocks << blockSize, true
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tidwall/gjson"
+)
+
+// testZnsIDNs is the zns id-ns -o json output of a namespace with up to 14
+// open zones, no active zone limit and 2 GiB zones in both LBA formats.
+const testZnsIDNs = `{"zoc":0,"ozcs":0,"mar":4294967295,"mor":13,"rrl":0,"frl":0,"rrl1":0,"rrl2":0,"rrl3":0,` +
+	`"frl1":0,"frl2":0,"frl3":0,"numzrwa":0,"zrwafg":0,"zrwasz":0,"zrwacap":0,` +
+	`"lbafe":[{"zsze":4194304,"zdes":0},{"zsze":524288,"zdes":0}]}`
+
+// testZoneReport returns a zns report-zones -o json output of a single zone
+// descriptor, out of zones matching the state filter.
+func testZoneReport(zones int, state string) string {
+	return `{"nr_zones":` + strconv.Itoa(zones) + `,"zones":[{"slba":"0x1000000","wp":"0x1000000",` +
+		`"cap":"0x43500","state":"` + state + `","type":"SEQWRITE_REQ","attrs":"0x00"}]}`
+}
+
+func TestZoneSize(t *testing.T) {
+	// 20 LBA formats, the last one with 64 KiB blocks.
+	formats := strings.Repeat(`{"ms":0,"ds":9,"rp":0},`, 19) + `{"ms":0,"ds":16,"rp":0}`
+	extensions := strings.Repeat(`{"zsze":4194304,"zdes":0},`, 19) + `{"zsze":32768,"zdes":0}`
+
+	for _, test := range []struct {
+		name    string
+		idNs    string
+		znsIDNs string
+		want    uint64
+		ok      bool
+	}{
+		{
+			"format 0",
+			`{"flbas":0,"lbafs":[{"ms":0,"ds":9,"rp":0},{"ms":0,"ds":12,"rp":0}]}`, testZnsIDNs,
+			2 << 30, true,
+		},
+		{
+			"format 1",
+			`{"flbas":1,"lbafs":[{"ms":0,"ds":9,"rp":0},{"ms":0,"ds":12,"rp":0}]}`, testZnsIDNs,
+			2 << 30, true,
+		},
+		{
+			"metadata at the end of the block",
+			`{"flbas":17,"lbafs":[{"ms":0,"ds":9,"rp":0},{"ms":8,"ds":12,"rp":0}]}`, testZnsIDNs,
+			2 << 30, true,
+		},
+		{
+			"format index bits 6:5",
+			`{"flbas":35,"lbafs":[` + formats + `]}`, `{"lbafe":[` + extensions + `]}`,
+			2 << 30, true,
+		},
+		{
+			"format without extension",
+			`{"flbas":3,"lbafs":[` + formats + `]}`, testZnsIDNs,
+			0, false,
+		},
+		{
+			"no flbas",
+			`{"lbafs":[{"ms":0,"ds":9,"rp":0}]}`, testZnsIDNs,
+			0, false,
+		},
+		{
+			"no LBA formats",
+			`{"flbas":0}`, testZnsIDNs,
+			0, false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := zoneSize(gjson.Parse(test.idNs), gjson.Parse(test.znsIDNs))
+			if got != test.want || ok != test.ok {
+				t.Errorf("zoneSize() = %d, %v, want %d, %v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestZnsCollector(t *testing.T) {
+	c := newTestLogPages(newZnsCollector([]string{"device"}, t.TempDir()), map[string]string{
+		"id-ns":              `{"nsze":7864320000,"flbas":1,"lbafs":[{"ms":0,"ds":9,"rp":0},{"ms":0,"ds":12,"rp":0}]}`,
+		"zns-id-ns":          testZnsIDNs,
+		"zns-report-zones:1": testZoneReport(3600, "EMPTY"),
+		"zns-report-zones:2": testZoneReport(2, "IMP_OPENED"),
+		"zns-report-zones:3": testZoneReport(1, "EXP_OPENED"),
+		"zns-report-zones:4": testZoneReport(4, "CLOSED"),
+		"zns-report-zones:5": testZoneReport(93, "FULL"),
+		"zns-report-zones:6": `{"nr_zones":0,"zones":[]}`,
+		"zns-report-zones:7": `{"nr_zones":0,"zones":[]}`,
+	})
+
+	expected := `
+# HELP nvme_zns_max_open_resources Maximum number of zones that can be open at the same time, absent when unlimited
+# TYPE nvme_zns_max_open_resources gauge
+nvme_zns_max_open_resources{device="/dev/nvme0n1"} 14
+# HELP nvme_zns_zone_size_bytes Size of the zones of the zoned namespace
+# TYPE nvme_zns_zone_size_bytes gauge
+nvme_zns_zone_size_bytes{device="/dev/nvme0n1"} 2.147483648e+09
+# HELP nvme_zns_zones Number of zones of the zoned namespace in the state
+# TYPE nvme_zns_zones gauge
+nvme_zns_zones{device="/dev/nvme0n1",state="closed"} 4
+nvme_zns_zones{device="/dev/nvme0n1",state="empty"} 3600
+nvme_zns_zones{device="/dev/nvme0n1",state="explicitly_open"} 1
+nvme_zns_zones{device="/dev/nvme0n1",state="full"} 93
+nvme_zns_zones{device="/dev/nvme0n1",state="implicitly_open"} 2
+nvme_zns_zones{device="/dev/nvme0n1",state="offline"} 0
+nvme_zns_zones{device="/dev/nvme0n1",state="read_only"} 0
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestZnsQueries(t *testing.T) {
+	sysRoot := t.TempDir()
+	writeTestFiles(t, sysRoot, map[string]string{
+		"block/nvme0n1/queue/zoned": "host-managed",
+		"block/nvme1n1/queue/zoned": "none",
+	})
+
+	c := newZnsCollector([]string{"device"}, sysRoot)
+
+	want := []string{
+		"id-ns", "zns-id-ns", "zns-report-zones:1", "zns-report-zones:2", "zns-report-zones:3",
+		"zns-report-zones:4", "zns-report-zones:5", "zns-report-zones:6", "zns-report-zones:7",
+	}
+
+	for device, want := range map[string][]string{"/dev/nvme0n1": want, "/dev/nvme1n1": nil} {
+		queries := c.queries(deviceSnapshot{info: gjson.Parse(`{"DevicePath":"` + device + `"}`)})
+		if !reflect.DeepEqual(queries, want) {
+			t.Errorf("queries of %s = %v, want %v", device, queries, want)
+		}
+	}
+}